@@ -2,12 +2,7 @@ package memberlistquic
 
 import (
 	"context"
-	"encoding/binary"
-	"io"
-	"net"
-	"time"
 
-	"github.com/hashicorp/memberlist"
 	"github.com/quic-go/quic-go"
 )
 
@@ -24,6 +19,28 @@ func (t *Transport) acceptLoop() {
 				continue
 			}
 		}
+		switch proto := conn.ConnectionState().TLS.NegotiatedProtocol; proto {
+		case t.alpn:
+		case t.protoALPN:
+			if err := t.authorizeConn(conn); err != nil {
+				t.logger.Printf("[WARN] memberlist-quic: %v", err)
+				continue
+			}
+			t.protoPool.AddInbound(conn)
+			t.startProtocolHandlers(conn)
+			continue
+		case t.bootstrapALPN:
+			t.wg.Add(1)
+			go t.serveBootstrap(conn)
+			continue
+		default:
+			t.routeConn(conn, proto)
+			continue
+		}
+		if err := t.authorizeConn(conn); err != nil {
+			t.logger.Printf("[WARN] memberlist-quic: %v", err)
+			continue
+		}
 		t.pool.AddInbound(conn)
 		t.startConnHandlers(conn)
 	}
@@ -36,15 +53,7 @@ func (t *Transport) receiveDatagrams(conn *quic.Conn) {
 		if err != nil {
 			return
 		}
-		select {
-		case t.packetCh <- &memberlist.Packet{
-			Buf:       msg,
-			From:      conn.RemoteAddr(),
-			Timestamp: time.Now(),
-		}:
-		case <-t.shutdownCh:
-			return
-		}
+		t.deliverPacket(conn, msg)
 	}
 }
 
@@ -55,13 +64,8 @@ func (t *Transport) acceptStreams(conn *quic.Conn) {
 		if err != nil {
 			return
 		}
-		sc := &quicStreamConn{
-			stream:     stream,
-			localAddr:  conn.LocalAddr(),
-			remoteAddr: conn.RemoteAddr(),
-		}
 		select {
-		case t.streamCh <- sc:
+		case t.streamCh <- newStreamConn(conn, stream):
 		case <-t.shutdownCh:
 			stream.Close()
 			return
@@ -76,32 +80,16 @@ func (t *Transport) acceptUniStreams(conn *quic.Conn) {
 		if err != nil {
 			return
 		}
-		go t.handleUniStream(stream, conn.RemoteAddr())
+		go t.handleUniStream(conn, stream)
 	}
 }
 
-func (t *Transport) handleUniStream(stream *quic.ReceiveStream, remoteAddr net.Addr) {
+func (t *Transport) handleUniStream(conn *quic.Conn, stream *quic.ReceiveStream) {
 	defer stream.CancelRead(0)
 
-	var hdr [4]byte
-	if _, err := io.ReadFull(stream, hdr[:]); err != nil {
-		return
-	}
-	size := binary.BigEndian.Uint32(hdr[:])
-	if size > 65536 {
+	buf, err := readFrame(stream)
+	if err != nil {
 		return
 	}
-	buf := make([]byte, size)
-	if _, err := io.ReadFull(stream, buf); err != nil {
-		return
-	}
-
-	select {
-	case t.packetCh <- &memberlist.Packet{
-		Buf:       buf,
-		From:      remoteAddr,
-		Timestamp: time.Now(),
-	}:
-	case <-t.shutdownCh:
-	}
+	t.deliverPacket(conn, buf)
 }
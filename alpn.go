@@ -0,0 +1,175 @@
+package memberlistquic
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"slices"
+	"sync"
+
+	"github.com/quic-go/quic-go"
+)
+
+// CloseCodeALPNUnavailable is the QUIC application error code used to
+// close connections for an ALPN protocol whose handler is not available.
+const CloseCodeALPNUnavailable quic.ApplicationErrorCode = 0x11
+
+// alpnRoute is a handler registered with HandleALPN.
+type alpnRoute struct {
+	conf    *tls.Config
+	handler func(*quic.Conn)
+}
+
+// HandleALPN routes inbound connections negotiating the ALPN protocol
+// proto, such as "h3", to handler instead of memberlist, so that other
+// QUIC services can share the transport's socket. handler is called on
+// a new goroutine for each connection and owns it.
+//
+// conf is the TLS config for those connections; its NextProtos is
+// ignored. If nil, the transport's own certificate is presented and
+// client certificates are not requested. Connections for proto are not
+// subject to the Authorizer.
+func (t *Transport) HandleALPN(proto string, conf *tls.Config, handler func(*quic.Conn)) error {
+	if proto == "" || len(proto) > 255 {
+		return fmt.Errorf("invalid ALPN protocol %q", proto)
+	}
+	if isTransportALPN(proto) {
+		return fmt.Errorf("ALPN protocol %q is reserved for the transport", proto)
+	}
+	if conf != nil {
+		conf = conf.Clone()
+		conf.NextProtos = []string{proto}
+	}
+	t.routesMu.Lock()
+	defer t.routesMu.Unlock()
+	if _, ok := t.routes[proto]; ok {
+		return fmt.Errorf("ALPN protocol %q is already handled", proto)
+	}
+	t.routes[proto] = &alpnRoute{conf: conf, handler: handler}
+	return nil
+}
+
+// isTransportALPN reports whether proto is a memberlist, protocol or
+// bootstrap protocol ID for any cluster label. Routing one elsewhere would hand
+// peers of another cluster to user code instead of refusing them.
+func isTransportALPN(proto string) bool {
+	for _, base := range []string{alpn, protocolALPN, bootstrapALPN} {
+		if _, ok := alpnLabel(base, proto); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// RemoveALPN stops routing connections for proto. Connections already
+// handed to its handler are unaffected.
+func (t *Transport) RemoveALPN(proto string) {
+	t.routesMu.Lock()
+	defer t.routesMu.Unlock()
+	delete(t.routes, proto)
+}
+
+// alpnRoute returns the route for a client offering protos, unless it
+// offers the transport's own protocol.
+func (t *Transport) alpnRoute(protos []string) (string, *alpnRoute) {
+	if slices.Contains(protos, t.alpn) {
+		return "", nil
+	}
+	t.routesMu.RLock()
+	defer t.routesMu.RUnlock()
+	for _, proto := range protos {
+		if route, ok := t.routes[proto]; ok {
+			return proto, route
+		}
+	}
+	return "", nil
+}
+
+// routeConn hands an accepted connection for another ALPN protocol to its
+// handler.
+func (t *Transport) routeConn(conn *quic.Conn, proto string) {
+	t.routesMu.RLock()
+	route := t.routes[proto]
+	t.routesMu.RUnlock()
+	if route == nil {
+		conn.CloseWithError(CloseCodeALPNUnavailable, "no handler for "+proto)
+		return
+	}
+	go route.handler(conn)
+}
+
+// ALPNListener accepts the connections for an ALPN protocol routed by
+// Transport.ListenALPN. It satisfies http3.QUICListener.
+type ALPNListener struct {
+	t      *Transport
+	proto  string
+	connCh chan *quic.Conn
+	doneCh chan struct{}
+	close  sync.Once
+}
+
+// ListenALPN returns a listener for inbound connections negotiating
+// proto, as an alternative to HandleALPN. For example, to serve HTTP/3 on
+// the gossip port:
+//
+//	ln, err := transport.ListenALPN(http3.NextProtoH3, tlsConf)
+//	go server.ServeListener(ln)
+func (t *Transport) ListenALPN(proto string, conf *tls.Config) (*ALPNListener, error) {
+	ln := &ALPNListener{
+		t:      t,
+		proto:  proto,
+		connCh: make(chan *quic.Conn, t.config.StreamQueueSize),
+		doneCh: make(chan struct{}),
+	}
+	if err := t.HandleALPN(proto, conf, ln.enqueue); err != nil {
+		return nil, err
+	}
+	return ln, nil
+}
+
+func (l *ALPNListener) enqueue(conn *quic.Conn) {
+	select {
+	case l.connCh <- conn:
+	case <-l.doneCh:
+		conn.CloseWithError(CloseCodeALPNUnavailable, "listener closed")
+	case <-l.t.shutdownCh:
+	}
+}
+
+// Accept returns the next connection.
+func (l *ALPNListener) Accept(ctx context.Context) (*quic.Conn, error) {
+	select {
+	case conn := <-l.connCh:
+		return conn, nil
+	case <-l.doneCh:
+		return nil, net.ErrClosed
+	case <-l.t.shutdownCh:
+		return nil, net.ErrClosed
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Addr returns the transport's local address.
+func (l *ALPNListener) Addr() net.Addr {
+	return l.t.packetConn.LocalAddr()
+}
+
+// Close stops routing connections to the listener and closes any not yet
+// accepted.
+func (l *ALPNListener) Close() error {
+	l.close.Do(func() {
+		l.t.RemoveALPN(l.proto)
+		close(l.doneCh)
+		for {
+			select {
+			case conn := <-l.connCh:
+				conn.CloseWithError(CloseCodeALPNUnavailable, "listener closed")
+			default:
+				return
+			}
+		}
+	})
+	return nil
+}
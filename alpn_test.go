@@ -0,0 +1,130 @@
+package memberlistquic
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/memberlist"
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+	"github.com/wjordan/memberlist-quic/tlsutil"
+	"github.com/wjordan/memberlist-quic/transporttest"
+)
+
+func TestALPNRouter(t *testing.T) {
+	caCert, caKey, err := tlsutil.GenerateCA("test-org", 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tr1, cfg1 := createTestTransport(t, caCert, caKey, "node-1")
+	_, cfg2 := createTestTransport(t, caCert, caKey, "node-2")
+
+	// Serve HTTP/3 on node-1's gossip port, presenting its node certificate
+	ln, err := tr1.ListenALPN(http3.NextProtoH3, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &http3.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "hello over "+r.Proto)
+	})}
+	go func() { _ = server.ServeListener(ln) }()
+	t.Cleanup(func() { _ = server.Close() })
+
+	if err := tr1.HandleALPN(http3.NextProtoH3, nil, func(*quic.Conn) {}); err == nil {
+		t.Fatal("expected error registering a protocol twice")
+	}
+	if err := tr1.HandleALPN(tr1.alpn, nil, func(*quic.Conn) {}); err == nil {
+		t.Fatal("expected error registering the memberlist protocol")
+	}
+
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(caCert)
+	client := &http.Client{Transport: &http3.Transport{
+		TLSClientConfig: &tls.Config{RootCAs: roots},
+	}}
+	t.Cleanup(client.CloseIdleConnections)
+
+	_, port, err := tr1.FinalAdvertiseAddr("", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := client.Get("https://127.0.0.1:" + strconv.Itoa(port) + "/")
+	if err != nil {
+		t.Fatalf("HTTP/3 request failed: %v", err)
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(body) != "hello over HTTP/3.0" {
+		t.Fatalf("unexpected response %q", body)
+	}
+	if n := tr1.pool.Len(); n != 0 {
+		t.Fatalf("expected HTTP/3 connection to bypass the pool, got %d", n)
+	}
+
+	// Gossip keeps working on the same socket
+	ml1, err := memberlist.Create(cfg1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ml1.Shutdown()
+	ml2, err := memberlist.Create(cfg2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ml2.Shutdown()
+	if _, err := ml2.Join([]string{advertiseAddr(t, ml1)}); err != nil {
+		t.Fatalf("join failed: %v", err)
+	}
+	waitForMembers(t, 2, ml1, ml2)
+
+	// Once the listener closes, HTTP/3 clients are refused
+	ln.Close()
+	client.CloseIdleConnections()
+	if _, err := client.Get("https://127.0.0.1:" + strconv.Itoa(port) + "/"); err == nil {
+		t.Fatal("expected HTTP/3 request to fail after closing the listener")
+	}
+}
+
+func TestALPNRouterReservedProtocols(t *testing.T) {
+	caCert, caKey, err := tlsutil.GenerateCA("test-org", 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, key, err := tlsutil.GenerateNodeCertWithIPs(caCert, caKey, "node-1", []net.IP{net.IPv4(127, 0, 0, 1)}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf, err := tlsutil.MutualTLSConfig(cert, key, caCert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	labelled, err := New(Config{BindAddr: "127.0.0.1", TLS: conf, ClusterLabel: "prod"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer labelled.Shutdown()
+
+	// Memberlist and bootstrap IDs are reserved whatever their label, so
+	// peers from other clusters are refused rather than routed
+	for _, proto := range []string{alpn, alpn + "+prod", alpn + "+staging", protocolALPN + "+staging", bootstrapALPN, bootstrapALPN + "+staging"} {
+		if err := labelled.HandleALPN(proto, nil, func(*quic.Conn) {}); err == nil {
+			t.Errorf("expected %q to be reserved", proto)
+		}
+	}
+
+	unlabelled, _ := createTestTransport(t, caCert, caKey, "node-2")
+	_, err = unlabelled.WriteToAddress([]byte("ping"), memberlist.Address{Addr: transporttest.Addr(t, labelled)})
+	if !IsLabelMismatch(err) {
+		t.Fatalf("expected label mismatch from unlabelled peer, got %v", err)
+	}
+}
@@ -0,0 +1,165 @@
+package memberlistquic
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/quic-go/quic-go"
+)
+
+// CloseCodeUnauthorized is the QUIC application error code used to close
+// connections rejected by the Authorizer.
+const CloseCodeUnauthorized quic.ApplicationErrorCode = 0x10
+
+// Authorizer decides whether an authenticated peer may connect. It
+// receives the verified certificate chain, leaf first, and returns an
+// error to reject the connection. It runs after every TLS handshake, for
+// both inbound and outbound connections.
+type Authorizer func(chain []*x509.Certificate) error
+
+// IsUnauthorized reports whether err is the result of a connection being
+// rejected by an Authorizer, on either side.
+func IsUnauthorized(err error) bool {
+	var authErr *unauthorizedError
+	if errors.As(err, &authErr) {
+		return true
+	}
+	var appErr *quic.ApplicationError
+	return errors.As(err, &appErr) && appErr.ErrorCode == CloseCodeUnauthorized
+}
+
+// unauthorizedError is returned locally when the Authorizer rejects a peer.
+type unauthorizedError struct {
+	addr net.Addr
+	err  error
+}
+
+func (e *unauthorizedError) Error() string {
+	return fmt.Sprintf("peer %s not authorized: %v", e.addr, e.err)
+}
+
+func (e *unauthorizedError) Unwrap() error { return e.err }
+
+// authorizeConn pins a new connection's peer with the configured
+// KnownPeers and applies the Authorizer, closing the connection with
+// CloseCodeUnauthorized if the peer is rejected. Both run only once the
+// handshake has completed.
+func (t *Transport) authorizeConn(conn *quic.Conn) error {
+	if t.config.KnownPeers == nil && t.config.Authorizer == nil {
+		return nil
+	}
+	state := conn.ConnectionState().TLS
+	var err error
+	if t.config.KnownPeers != nil {
+		err = t.config.KnownPeers.PinConnection(state)
+	}
+	if err == nil && t.config.Authorizer != nil {
+		chain := state.PeerCertificates
+		if len(state.VerifiedChains) > 0 {
+			chain = state.VerifiedChains[0]
+		}
+		err = errors.New("no peer certificate")
+		if len(chain) > 0 {
+			err = t.config.Authorizer(chain)
+		}
+	}
+	if err != nil {
+		_ = conn.CloseWithError(CloseCodeUnauthorized, "unauthorized")
+		return &unauthorizedError{addr: conn.RemoteAddr(), err: err}
+	}
+	return nil
+}
+
+// Identities returns the names a certificate identifies its subject by:
+// the Common Name, DNS SANs and URI SANs.
+func Identities(cert *x509.Certificate) []string {
+	var ids []string
+	if cert.Subject.CommonName != "" {
+		ids = append(ids, cert.Subject.CommonName)
+	}
+	ids = append(ids, cert.DNSNames...)
+	for _, uri := range cert.URIs {
+		ids = append(ids, uri.String())
+	}
+	return ids
+}
+
+// AllowIdentities authorizes peers whose certificate has one of the given
+// identities (see Identities).
+func AllowIdentities(ids ...string) Authorizer {
+	allowed := make(map[string]struct{}, len(ids))
+	for _, id := range ids {
+		allowed[id] = struct{}{}
+	}
+	return func(chain []*x509.Certificate) error {
+		for _, id := range Identities(chain[0]) {
+			if _, ok := allowed[id]; ok {
+				return nil
+			}
+		}
+		return fmt.Errorf("%s is not in the allow-list", describePeer(chain[0]))
+	}
+}
+
+// MatchIdentity authorizes peers with an identity matching re (see
+// Identities). Anchor the expression to match whole identities.
+func MatchIdentity(re *regexp.Regexp) Authorizer {
+	return func(chain []*x509.Certificate) error {
+		if slices.ContainsFunc(Identities(chain[0]), re.MatchString) {
+			return nil
+		}
+		return fmt.Errorf("%s does not match %s", describePeer(chain[0]), re)
+	}
+}
+
+// RequireRole authorizes peers whose certificate lists one of the given
+// roles as an Organizational Unit.
+func RequireRole(roles ...string) Authorizer {
+	return func(chain []*x509.Certificate) error {
+		for _, ou := range chain[0].Subject.OrganizationalUnit {
+			if slices.Contains(roles, ou) {
+				return nil
+			}
+		}
+		return fmt.Errorf("%s has none of the roles %s", describePeer(chain[0]), strings.Join(roles, ", "))
+	}
+}
+
+// AnyOf authorizes peers accepted by at least one of the given policies.
+func AnyOf(policies ...Authorizer) Authorizer {
+	return func(chain []*x509.Certificate) error {
+		errs := make([]error, 0, len(policies))
+		for _, policy := range policies {
+			err := policy(chain)
+			if err == nil {
+				return nil
+			}
+			errs = append(errs, err)
+		}
+		if len(errs) == 0 {
+			return errors.New("no policy accepted the peer")
+		}
+		return errors.Join(errs...)
+	}
+}
+
+// AllOf authorizes peers accepted by every one of the given policies.
+func AllOf(policies ...Authorizer) Authorizer {
+	return func(chain []*x509.Certificate) error {
+		for _, policy := range policies {
+			if err := policy(chain); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+func describePeer(cert *x509.Certificate) string {
+	return fmt.Sprintf("peer %q", cert.Subject.CommonName)
+}
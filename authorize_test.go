@@ -0,0 +1,119 @@
+package memberlistquic
+
+import (
+	"crypto/x509"
+	"io"
+	"log"
+	"net"
+	"net/url"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/wjordan/memberlist-quic/tlsutil"
+	"github.com/wjordan/memberlist-quic/transporttest"
+)
+
+func TestAuthorizerPolicies(t *testing.T) {
+	cert := &x509.Certificate{DNSNames: []string{"db-1.example.com"}}
+	cert.Subject.CommonName = "node-1"
+	cert.Subject.OrganizationalUnit = []string{"member", "db"}
+	spiffe, _ := url.Parse("spiffe://example.com/node-1")
+	cert.URIs = []*url.URL{spiffe}
+	chain := []*x509.Certificate{cert}
+
+	tests := []struct {
+		name   string
+		policy Authorizer
+		allow  bool
+	}{
+		{"allow CN", AllowIdentities("node-2", "node-1"), true},
+		{"allow DNS", AllowIdentities("db-1.example.com"), true},
+		{"allow URI", AllowIdentities("spiffe://example.com/node-1"), true},
+		{"deny", AllowIdentities("node-2"), false},
+		{"match", MatchIdentity(regexp.MustCompile(`^node-\d+$`)), true},
+		{"no match", MatchIdentity(regexp.MustCompile(`^web-`)), false},
+		{"role", RequireRole("admin", "db"), true},
+		{"missing role", RequireRole("admin"), false},
+		{"any of", AnyOf(RequireRole("admin"), AllowIdentities("node-1")), true},
+		{"any of none", AnyOf(), false},
+		{"all of", AllOf(RequireRole("member"), AllowIdentities("node-1")), true},
+		{"all of denied", AllOf(RequireRole("member"), AllowIdentities("node-2")), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.policy(chain)
+			if allowed := err == nil; allowed != tt.allow {
+				t.Fatalf("expected allow=%v, got error %v", tt.allow, err)
+			}
+		})
+	}
+}
+
+func TestAuthorizer(t *testing.T) {
+	caCert, caKey, err := tlsutil.GenerateCA("test-org", 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newTransport := func(name string, roles []string, authorizer Authorizer) *Transport {
+		t.Helper()
+		cert, key, err := tlsutil.GenerateNodeCertWithOptions(caCert, caKey, tlsutil.NodeCertOptions{
+			NodeID:              name,
+			IPs:                 []net.IP{net.IPv4(127, 0, 0, 1)},
+			OrganizationalUnits: roles,
+			Validity:            time.Hour,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		conf, err := tlsutil.MutualTLSConfig(cert, key, caCert)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tr, err := New(Config{
+			BindAddr:   "127.0.0.1",
+			TLS:        conf,
+			Authorizer: authorizer,
+			Logger:     log.New(io.Discard, "", 0),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { _ = tr.Shutdown() })
+		return tr
+	}
+
+	policy := RequireRole("member")
+	member1 := newTransport("member-1", []string{"member"}, policy)
+	member2 := newTransport("member-2", []string{"member"}, policy)
+	outsider := newTransport("outsider", nil, nil)
+
+	// Members connect to each other
+	conn, err := member1.DialTimeout(transporttest.Addr(t, member2), 5*time.Second)
+	if err != nil {
+		t.Fatalf("dial between members: %v", err)
+	}
+	conn.Close()
+
+	// A member refuses to dial an outsider
+	_, err = member1.WriteTo([]byte("ping"), transporttest.Addr(t, outsider))
+	if !IsUnauthorized(err) {
+		t.Fatalf("expected local authorization failure, got %v", err)
+	}
+
+	// An outsider's connection is closed by the member with the
+	// unauthorized code. Its own handshake completes first, so the
+	// rejection shows up on the connection rather than the dial.
+	memberAddr := transporttest.Addr(t, member1)
+	conn, err = outsider.DialTimeout(memberAddr, 5*time.Second)
+	if err == nil {
+		defer conn.Close()
+		_, err = conn.Read(make([]byte, 1))
+	}
+	if !IsUnauthorized(err) {
+		t.Fatalf("expected remote authorization failure, got %v", err)
+	}
+	if member1.ConnPool().Len() != 1 {
+		t.Fatalf("expected only the member connection to be pooled, got %d", member1.ConnPool().Len())
+	}
+}
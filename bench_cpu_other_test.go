@@ -0,0 +1,10 @@
+//go:build !unix
+
+package memberlistquic
+
+import "time"
+
+// processCPUTime is unsupported on this platform and always reports zero.
+func processCPUTime() time.Duration {
+	return 0
+}
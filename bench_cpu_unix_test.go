@@ -0,0 +1,18 @@
+//go:build unix
+
+package memberlistquic
+
+import (
+	"syscall"
+	"time"
+)
+
+// processCPUTime returns the user+system CPU time the process has
+// consumed.
+func processCPUTime() time.Duration {
+	var ru syscall.Rusage
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &ru); err != nil {
+		return 0
+	}
+	return time.Duration(ru.Utime.Nano() + ru.Stime.Nano())
+}
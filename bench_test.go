@@ -0,0 +1,448 @@
+package memberlistquic
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/memberlist"
+	"github.com/wjordan/memberlist-quic/tlsutil"
+)
+
+// Run with e.g.
+//
+//	go test -run '^$' -bench . -benchmem -benchjson bench.json
+//
+// to compare the QUIC transport against memberlist.NetTransport and write
+// the final result of each benchmark as JSON.
+var benchJSON = flag.String("benchjson", "", "write benchmark results as JSON to this file")
+
+// benchResult is one entry of the -benchjson output.
+type benchResult struct {
+	Name      string             `json:"name"`
+	Transport string             `json:"transport"`
+	Nodes     int                `json:"nodes,omitempty"`
+	N         int                `json:"n"`
+	Metrics   map[string]float64 `json:"metrics"`
+}
+
+var (
+	benchResultsMu sync.Mutex
+	benchResults   = map[string]benchResult{}
+)
+
+func TestMain(m *testing.M) {
+	flag.Parse()
+	code := m.Run()
+	if *benchJSON != "" && len(benchResults) > 0 {
+		if err := writeBenchResults(*benchJSON); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to write %s: %v\n", *benchJSON, err)
+			code = 1
+		}
+	}
+	os.Exit(code)
+}
+
+func writeBenchResults(path string) error {
+	names := make([]string, 0, len(benchResults))
+	for name := range benchResults {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	results := make([]benchResult, 0, len(names))
+	for _, name := range names {
+		results = append(results, benchResults[name])
+	}
+	data, err := json.MarshalIndent(results, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o644)
+}
+
+// recordBench reports metrics to the benchmark framework and keeps the
+// last (largest b.N) run of each benchmark for -benchjson.
+func recordBench(b *testing.B, kind string, nodes int, metrics map[string]float64) {
+	for unit, v := range metrics {
+		b.ReportMetric(v, unit)
+	}
+	benchResultsMu.Lock()
+	benchResults[b.Name()] = benchResult{
+		Name:      b.Name(),
+		Transport: kind,
+		Nodes:     nodes,
+		N:         b.N,
+		Metrics:   metrics,
+	}
+	benchResultsMu.Unlock()
+}
+
+const (
+	kindQUIC = "quic"
+	kindNet  = "net"
+)
+
+var benchKinds = []string{kindQUIC, kindNet}
+
+// benchEnv creates transports of either kind on loopback.
+type benchEnv struct {
+	b             *testing.B
+	caCert, caKey []byte
+}
+
+func newBenchEnv(b *testing.B) *benchEnv {
+	b.Helper()
+	caCert, caKey, err := tlsutil.GenerateCA("bench-org", 24*time.Hour)
+	if err != nil {
+		b.Fatal(err)
+	}
+	return &benchEnv{b: b, caCert: caCert, caKey: caKey}
+}
+
+func (e *benchEnv) transport(kind, name string) memberlist.NodeAwareTransport {
+	e.b.Helper()
+	var (
+		tr  memberlist.NodeAwareTransport
+		err error
+	)
+	switch kind {
+	case kindQUIC:
+		nodeCert, nodeKey, err := tlsutil.GenerateNodeCertWithIPs(e.caCert, e.caKey, name, []net.IP{net.IPv4(127, 0, 0, 1)}, 24*time.Hour)
+		if err != nil {
+			e.b.Fatal(err)
+		}
+		tlsConf, err := tlsutil.MutualTLSConfig(nodeCert, nodeKey, e.caCert)
+		if err != nil {
+			e.b.Fatal(err)
+		}
+		tr, err = New(Config{
+			BindAddr: "127.0.0.1",
+			TLS:      tlsConf,
+			Logger:   log.New(io.Discard, "", 0),
+		})
+		if err != nil {
+			e.b.Fatal(err)
+		}
+	case kindNet:
+		tr, err = memberlist.NewNetTransport(&memberlist.NetTransportConfig{
+			BindAddrs: []string{"127.0.0.1"},
+			Logger:    log.New(io.Discard, "", 0),
+		})
+	default:
+		err = fmt.Errorf("unknown transport kind %q", kind)
+	}
+	if err != nil {
+		e.b.Fatal(err)
+	}
+	return tr
+}
+
+func benchAddr(b *testing.B, tr memberlist.NodeAwareTransport) string {
+	b.Helper()
+	ip, port, err := tr.FinalAdvertiseAddr("", 0)
+	if err != nil {
+		b.Fatal(err)
+	}
+	return net.JoinHostPort(ip.String(), strconv.Itoa(port))
+}
+
+// BenchmarkPacketRoundTrip measures the latency of a packet round trip
+// between two transports, the path used by probes and gossip.
+func BenchmarkPacketRoundTrip(b *testing.B) {
+	for _, kind := range benchKinds {
+		b.Run(kind, func(b *testing.B) {
+			env := newBenchEnv(b)
+			a, c := env.transport(kind, "node-a"), env.transport(kind, "node-c")
+			defer a.Shutdown()
+			defer c.Shutdown()
+			aAddr, cAddr := benchAddr(b, a), benchAddr(b, c)
+			payload := make([]byte, 512)
+
+			// Echo packets back from c to a
+			stopEcho := make(chan struct{})
+			defer close(stopEcho)
+			go func() {
+				for {
+					select {
+					case p := <-c.PacketCh():
+						_, _ = c.WriteTo(p.Buf, aAddr)
+					case <-stopEcho:
+						return
+					}
+				}
+			}()
+
+			roundTrip := func() bool {
+				if _, err := a.WriteTo(payload, cAddr); err != nil {
+					b.Fatal(err)
+				}
+				select {
+				case <-a.PacketCh():
+					return true
+				case <-time.After(time.Second):
+					return false
+				}
+			}
+			// Warm up connection state outside the timed loop
+			for !roundTrip() {
+			}
+
+			var cpu cpuTimer
+			b.ReportAllocs()
+			b.ResetTimer()
+			cpu.Start()
+			start := time.Now()
+			lost := 0
+			for i := 0; i < b.N; i++ {
+				if !roundTrip() {
+					lost++
+				}
+			}
+			elapsed := time.Since(start)
+			b.StopTimer()
+			cpu.Stop()
+
+			recordBench(b, kind, 2, map[string]float64{
+				"rtt-us":    float64(elapsed.Microseconds()) / float64(b.N),
+				"lost/op":   float64(lost) / float64(b.N),
+				"cpu-us/op": float64(cpu.Elapsed().Microseconds()) / float64(b.N),
+			})
+		})
+	}
+}
+
+// BenchmarkStreamThroughput measures bulk transfer over a stream, the path
+// used by push-pull state sync.
+func BenchmarkStreamThroughput(b *testing.B) {
+	const chunk = 64 * 1024
+	for _, kind := range benchKinds {
+		b.Run(kind, func(b *testing.B) {
+			env := newBenchEnv(b)
+			a, c := env.transport(kind, "node-a"), env.transport(kind, "node-c")
+			defer a.Shutdown()
+			defer c.Shutdown()
+
+			conn, err := a.DialAddressTimeout(memberlist.Address{Addr: benchAddr(b, c)}, 0)
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer conn.Close()
+
+			done := make(chan int64, 1)
+			go func() {
+				remote := <-c.StreamCh()
+				defer remote.Close()
+				n, _ := io.Copy(io.Discard, remote)
+				done <- n
+			}()
+
+			buf := make([]byte, chunk)
+			var cpu cpuTimer
+			b.SetBytes(chunk)
+			b.ReportAllocs()
+			b.ResetTimer()
+			cpu.Start()
+			start := time.Now()
+			for i := 0; i < b.N; i++ {
+				if _, err := conn.Write(buf); err != nil {
+					b.Fatal(err)
+				}
+			}
+			_ = conn.Close()
+			received := <-done
+			elapsed := time.Since(start)
+			b.StopTimer()
+			cpu.Stop()
+
+			if received != int64(b.N)*chunk {
+				b.Fatalf("received %d bytes, want %d", received, int64(b.N)*chunk)
+			}
+			recordBench(b, kind, 2, map[string]float64{
+				"MB/s":      float64(received) / elapsed.Seconds() / 1e6,
+				"cpu-us/op": float64(cpu.Elapsed().Microseconds()) / float64(b.N),
+			})
+		})
+	}
+}
+
+// metaDelegate serves mutable node metadata so metadata changes can be
+// timed as they gossip through the cluster.
+type metaDelegate struct {
+	mu   sync.Mutex
+	meta []byte
+}
+
+func (d *metaDelegate) NodeMeta(limit int) []byte {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.meta
+}
+
+func (d *metaDelegate) set(meta []byte) {
+	d.mu.Lock()
+	d.meta = meta
+	d.mu.Unlock()
+}
+
+func (d *metaDelegate) NotifyMsg([]byte)                           {}
+func (d *metaDelegate) GetBroadcasts(overhead, limit int) [][]byte { return nil }
+func (d *metaDelegate) LocalState(join bool) []byte                { return nil }
+func (d *metaDelegate) MergeRemoteState(buf []byte, join bool)     {}
+
+type benchNode struct {
+	list     *memberlist.Memberlist
+	delegate *metaDelegate
+}
+
+func (e *benchEnv) startNode(kind string, i int) *benchNode {
+	e.b.Helper()
+	name := fmt.Sprintf("node-%d", i)
+	tr := e.transport(kind, name)
+
+	d := &metaDelegate{meta: []byte("v0")}
+	cfg := memberlist.DefaultLANConfig()
+	cfg.Name = name
+	cfg.Transport = tr
+	cfg.Delegate = d
+	cfg.AdvertiseAddr = "127.0.0.1"
+	_, port, err := tr.FinalAdvertiseAddr("", 0)
+	if err != nil {
+		e.b.Fatal(err)
+	}
+	cfg.AdvertisePort = port
+	cfg.LogOutput = io.Discard
+	cfg.GossipInterval = 50 * time.Millisecond
+	cfg.ProbeInterval = 500 * time.Millisecond
+	cfg.PushPullInterval = 0
+
+	list, err := memberlist.Create(cfg)
+	if err != nil {
+		e.b.Fatal(err)
+	}
+	return &benchNode{list: list, delegate: d}
+}
+
+// cpuTimer accumulates the process CPU time consumed while running, so
+// that it can be paused alongside the benchmark timer.
+type cpuTimer struct {
+	start   time.Duration
+	total   time.Duration
+	running bool
+}
+
+func (c *cpuTimer) Start() {
+	if !c.running {
+		c.start = processCPUTime()
+		c.running = true
+	}
+}
+
+func (c *cpuTimer) Stop() {
+	if c.running {
+		c.total += processCPUTime() - c.start
+		c.running = false
+	}
+}
+
+func (c *cpuTimer) Elapsed() time.Duration {
+	c.Stop()
+	return c.total
+}
+
+func waitConverged(b *testing.B, nodes []*benchNode, done func(*memberlist.Memberlist) bool) {
+	b.Helper()
+	deadline := time.Now().Add(30 * time.Second)
+	for time.Now().Before(deadline) {
+		converged := true
+		for _, n := range nodes {
+			if !done(n.list) {
+				converged = false
+				break
+			}
+		}
+		if converged {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	b.Fatal("cluster did not converge")
+}
+
+// BenchmarkCluster runs an N node cluster on loopback and measures the
+// time for all nodes to see each other after joining, and the time for a
+// metadata update to gossip to every node.
+func BenchmarkCluster(b *testing.B) {
+	for _, size := range []int{3, 10} {
+		for _, kind := range benchKinds {
+			b.Run(fmt.Sprintf("%s/nodes=%d", kind, size), func(b *testing.B) {
+				env := newBenchEnv(b)
+				var joinTotal, gossipTotal time.Duration
+				var cpu cpuTimer
+				b.ReportAllocs()
+				b.ResetTimer()
+
+				// Only joining and gossip are timed; starting and
+				// stopping nodes is not
+				for i := 0; i < b.N; i++ {
+					b.StopTimer()
+					nodes := make([]*benchNode, size)
+					for j := range nodes {
+						nodes[j] = env.startNode(kind, j)
+					}
+					seed := nodes[0].list.LocalNode().FullAddress().Addr
+					b.StartTimer()
+					cpu.Start()
+
+					start := time.Now()
+					for _, n := range nodes[1:] {
+						if _, err := n.list.Join([]string{seed}); err != nil {
+							b.Fatal(err)
+						}
+					}
+					waitConverged(b, nodes, func(ml *memberlist.Memberlist) bool {
+						return ml.NumMembers() == size
+					})
+					joinTotal += time.Since(start)
+
+					meta := []byte(fmt.Sprintf("v%d", i+1))
+					nodes[0].delegate.set(meta)
+					start = time.Now()
+					if err := nodes[0].list.UpdateNode(time.Second); err != nil {
+						b.Fatal(err)
+					}
+					origin := nodes[0].list.LocalNode().Name
+					waitConverged(b, nodes, func(ml *memberlist.Memberlist) bool {
+						for _, m := range ml.Members() {
+							if m.Name == origin {
+								return string(m.Meta) == string(meta)
+							}
+						}
+						return false
+					})
+					gossipTotal += time.Since(start)
+
+					b.StopTimer()
+					cpu.Stop()
+					for _, n := range nodes {
+						_ = n.list.Shutdown()
+					}
+				}
+
+				recordBench(b, kind, size, map[string]float64{
+					"join-ms":   float64(joinTotal.Milliseconds()) / float64(b.N),
+					"gossip-ms": float64(gossipTotal.Milliseconds()) / float64(b.N),
+					"cpu-ms/op": float64(cpu.Elapsed().Milliseconds()) / float64(b.N),
+				})
+			})
+		}
+	}
+}
@@ -0,0 +1,249 @@
+package memberlistquic
+
+import (
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/quic-go/quic-go"
+	"github.com/wjordan/memberlist-quic/tlsutil"
+)
+
+const (
+	bootstrapALPN = "memberlist-quic-bootstrap/1"
+
+	// bootstrapTimeout bounds a seed's handling of a bootstrap connection.
+	bootstrapTimeout = 10 * time.Second
+
+	defaultBootstrapValidity = 24 * time.Hour
+)
+
+// BootstrapConfig enables a seed to issue node certificates to new nodes
+// presenting a join token, so that nodes need not be provisioned with a
+// certificate before joining.
+//
+// Bootstrap connections use a separate ALPN on the same socket. The seed
+// is authenticated by its certificate as usual, but the new node has no
+// certificate yet and is authenticated by the token alone. Anyone holding
+// the token can therefore request a certificate, and without a check on
+// the requested names could obtain one for an existing member and
+// impersonate it; Authorize or Policy must provide that check. Requests
+// are not rate limited, so the token should be kept secret and rotated.
+type BootstrapConfig struct {
+	// Token is the pre-shared join token new nodes must present.
+	Token string
+
+	// CACert and CAKey sign issued certificates (see tlsutil.SignCSR).
+	// CAKey must not be encrypted.
+	CACert []byte
+	CAKey  []byte
+
+	// Validity of issued certificates. Defaults to 24 hours.
+	Validity time.Duration
+
+	// Authorize vets each certificate request after the token is checked,
+	// for example against an inventory of the nodes a token may claim.
+	// Policy restricts the names and addresses requests may contain; its
+	// MaxTTL is ignored in favour of Validity. At least one of them is
+	// required, and a Policy used alone must set AllowedNames.
+	Authorize func(csr *x509.CertificateRequest, remoteAddr net.Addr) error
+	Policy    *tlsutil.IssuerPolicy
+}
+
+// BootstrapRequest describes the certificate a new node requests from a
+// seed with Transport.Bootstrap.
+type BootstrapRequest struct {
+	// Token is the seed's join token.
+	Token string
+
+	// CACert is the cluster's CA bundle, which verifies the seed and,
+	// once bootstrapped, all peers.
+	CACert []byte
+
+	// ServerName verifies the seed's certificate. Defaults to the node
+	// name of a "name/host:port" seed address, or else the seed's host.
+	ServerName string
+
+	// Cert describes the requested certificate: its NodeID, SANs and key
+	// options. Validity and roles are chosen by the seed.
+	Cert tlsutil.NodeCertOptions
+}
+
+// Bootstrap obtains a node certificate from a seed running with
+// Config.Bootstrap. It generates a key and CSR, presents the join token,
+// and on success reloads the transport's TLS config with the issued
+// certificate, after which the node can join the cluster normally.
+//
+// The returned certificate chain and key, encoded as requested by
+// req.Cert.Key, should be persisted so the node need not bootstrap again.
+// The transport is reloaded with tlsutil.MutualTLSConfig; callers needing
+// further TLS settings can build their own config from them and call
+// ReloadTLS.
+func (t *Transport) Bootstrap(ctx context.Context, seed string, req BootstrapRequest) (certPEM, keyPEM []byte, err error) {
+	caPool, err := tlsutil.CertPoolFromPEM(req.CACert)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	serverName := req.ServerName
+	name, hostPort, ok := strings.Cut(seed, "/")
+	if !ok {
+		name, hostPort = "", seed
+	}
+	udpAddr, err := net.ResolveUDPAddr("udp", hostPort)
+	if err != nil {
+		return nil, nil, err
+	}
+	if serverName == "" {
+		serverName = name
+	}
+	if serverName == "" {
+		serverName, _, _ = net.SplitHostPort(hostPort)
+	}
+
+	csrPEM, keyPEM, err := tlsutil.GenerateCSR(req.Cert)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	conn, err := t.transport.Dial(ctx, udpAddr, &tls.Config{
+		RootCAs:    caPool,
+		ServerName: serverName,
+		NextProtos: []string{t.bootstrapALPN},
+		MinVersion: tls.VersionTLS13,
+	}, t.pool.quicConfig)
+	if err != nil {
+		err = t.dialError(hostPort, t.bootstrapALPN, err)
+		var unsupported *unsupportedProtocolError
+		if errors.As(err, &unsupported) {
+			return nil, nil, fmt.Errorf("bootstrap dial %s: seed does not serve bootstrap: %w", seed, err)
+		}
+		return nil, nil, fmt.Errorf("bootstrap dial %s: %w", seed, err)
+	}
+	defer conn.CloseWithError(0, "")
+
+	stream, err := conn.OpenStreamSync(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		stream.SetDeadline(deadline)
+	}
+	if err := writeFrame(stream, []byte(req.Token)); err != nil {
+		return nil, nil, err
+	}
+	if err := writeFrame(stream, csrPEM); err != nil {
+		return nil, nil, err
+	}
+	stream.Close()
+
+	certPEM, err = readResponse(stream)
+	if err != nil {
+		return nil, nil, fmt.Errorf("bootstrap from %s: %w", seed, err)
+	}
+
+	tlsKeyPEM := keyPEM
+	if len(req.Cert.Key.Password) > 0 {
+		if tlsKeyPEM, err = tlsutil.DecryptPrivateKeyPEM(keyPEM, req.Cert.Key.Password); err != nil {
+			return nil, nil, err
+		}
+	}
+	conf, err := tlsutil.MutualTLSConfig(certPEM, tlsKeyPEM, req.CACert)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid certificate from %s: %w", seed, err)
+	}
+	if err := t.ReloadTLS(conf, true); err != nil {
+		return nil, nil, err
+	}
+	return certPEM, keyPEM, nil
+}
+
+// isBootstrapHello reports whether a client is requesting a bootstrap
+// connection this transport serves.
+func (t *Transport) isBootstrapHello(hello *tls.ClientHelloInfo) bool {
+	return t.config.Bootstrap != nil && slices.Contains(hello.SupportedProtos, t.bootstrapALPN)
+}
+
+// serverOnlyTLSConfig derives a server config for proto from conf that
+// presents the certificate as usual but does not ask for one, as for
+// bootstrap clients which have none yet.
+func serverOnlyTLSConfig(conf *tls.Config, proto string) *tls.Config {
+	conf = conf.Clone()
+	conf.NextProtos = []string{proto}
+	conf.ClientAuth = tls.NoClientCert
+	conf.VerifyPeerCertificate = nil
+	conf.VerifyConnection = nil
+	return conf
+}
+
+// serveBootstrap handles a bootstrap connection: it reads the join token
+// and CSR and responds with a signed certificate or an error.
+func (t *Transport) serveBootstrap(conn *quic.Conn) {
+	defer t.wg.Done()
+	ctx, cancel := context.WithTimeout(t.dialContext(), bootstrapTimeout)
+	defer cancel()
+	defer conn.CloseWithError(0, "")
+
+	stream, err := conn.AcceptStream(ctx)
+	if err != nil {
+		return
+	}
+	stream.SetDeadline(time.Now().Add(bootstrapTimeout))
+
+	token, err := readFrame(stream)
+	if err != nil {
+		return
+	}
+	csrPEM, err := readFrame(stream)
+	if err != nil {
+		return
+	}
+
+	certPEM, err := t.issueBootstrapCert(token, csrPEM, conn.RemoteAddr())
+	if err != nil {
+		t.logger.Printf("[WARN] memberlist-quic: bootstrap from %s rejected: %v", conn.RemoteAddr(), err)
+	}
+	if err := writeResponse(stream, certPEM, err); err != nil {
+		return
+	}
+	stream.Close()
+
+	// Let the client close the connection once it has read the response
+	select {
+	case <-conn.Context().Done():
+	case <-ctx.Done():
+	}
+}
+
+func (t *Transport) issueBootstrapCert(token, csrPEM []byte, remoteAddr net.Addr) ([]byte, error) {
+	bc := t.config.Bootstrap
+	if subtle.ConstantTimeCompare(token, []byte(bc.Token)) != 1 {
+		return nil, errors.New("invalid join token")
+	}
+	csr, err := tlsutil.ParseCSR(csrPEM)
+	if err != nil {
+		return nil, err
+	}
+	if bc.Authorize != nil {
+		if err := bc.Authorize(csr, remoteAddr); err != nil {
+			return nil, err
+		}
+	}
+	certPEM, err := t.bootstrapIssuer.Sign(csrPEM, bc.Validity)
+	if err != nil {
+		return nil, err
+	}
+	nodeID := csr.Subject.CommonName
+	if len(csr.URIs) > 0 {
+		nodeID = csr.URIs[0].String()
+	}
+	t.logger.Printf("[INFO] memberlist-quic: issued certificate for %s to %s", nodeID, remoteAddr)
+	return certPEM, nil
+}
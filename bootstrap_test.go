@@ -0,0 +1,220 @@
+package memberlistquic
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/memberlist"
+	"github.com/wjordan/memberlist-quic/tlsutil"
+	"github.com/wjordan/memberlist-quic/transporttest"
+)
+
+func TestBootstrap(t *testing.T) {
+	caCert, caKey, err := tlsutil.GenerateCA("test-org", 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The seed is provisioned with a certificate and the CA key
+	seedCert, seedKey, err := tlsutil.GenerateNodeCertWithIPs(caCert, caKey, "seed", []net.IP{net.IPv4(127, 0, 0, 1)}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seedConf, err := tlsutil.MutualTLSConfig(seedCert, seedKey, caCert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seedTransport, err := New(Config{
+		BindAddr: "127.0.0.1",
+		TLS:      seedConf,
+		Bootstrap: &BootstrapConfig{
+			Token:    "s3cret",
+			CACert:   caCert,
+			CAKey:    caKey,
+			Validity: time.Hour,
+			Authorize: func(csr *x509.CertificateRequest, _ net.Addr) error {
+				if !strings.HasPrefix(csr.Subject.CommonName, "node-") {
+					return errors.New("node name not allowed")
+				}
+				return nil
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = seedTransport.Shutdown() })
+
+	newList := func(name string, tr *Transport) *memberlist.Memberlist {
+		cfg := memberlist.DefaultLANConfig()
+		cfg.Name = name
+		cfg.BindAddr = "127.0.0.1"
+		cfg.BindPort = 0
+		cfg.AdvertisePort = 0
+		cfg.Transport = tr
+		cfg.LogOutput = io.Discard
+		ml, err := memberlist.Create(cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { _ = ml.Shutdown() })
+		return ml
+	}
+	seedList := newList("seed", seedTransport)
+	seed := advertiseAddr(t, seedList)
+
+	// The new node starts without a certificate
+	tr, err := New(Config{BindAddr: "127.0.0.1", TLS: &tls.Config{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = tr.Shutdown() })
+
+	req := BootstrapRequest{
+		Token:  "s3cret",
+		CACert: caCert,
+		Cert: tlsutil.NodeCertOptions{
+			NodeID: "node-1",
+			IPs:    []net.IP{net.IPv4(127, 0, 0, 1)},
+		},
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	bad := req
+	bad.Token = "wrong"
+	if _, _, err := tr.Bootstrap(ctx, seed, bad); err == nil || !strings.Contains(err.Error(), "invalid join token") {
+		t.Fatalf("expected invalid join token error, got %v", err)
+	}
+	bad = req
+	bad.Cert.NodeID = "admin"
+	if _, _, err := tr.Bootstrap(ctx, seed, bad); err == nil || !strings.Contains(err.Error(), "node name not allowed") {
+		t.Fatalf("expected rejected node name, got %v", err)
+	}
+	bad = req
+	bad.ServerName = "other"
+	if _, _, err := tr.Bootstrap(ctx, seed, bad); err == nil {
+		t.Fatal("expected seed verification to fail for the wrong server name")
+	}
+
+	certPEM, keyPEM, err := tr.Bootstrap(ctx, seed, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tls.X509KeyPair(certPEM, keyPEM); err != nil {
+		t.Fatalf("issued certificate does not match key: %v", err)
+	}
+	local, err := tr.LocalCertificate()
+	if err != nil || local.NodeID != "node-1" {
+		t.Fatalf("expected transport reloaded with node-1 certificate, got %q, %v", local.NodeID, err)
+	}
+
+	// Bootstrap connections are not pooled as members
+	if peers := seedTransport.PeerCertificates(); len(peers) != 0 {
+		t.Fatalf("unexpected pooled peers %+v", peers)
+	}
+
+	ml := newList("node-1", tr)
+	if _, err := ml.Join([]string{seed}); err != nil {
+		t.Fatalf("join after bootstrap failed: %v", err)
+	}
+	waitForMembers(t, 2, seedList, ml)
+}
+
+func TestBootstrapDisabled(t *testing.T) {
+	caCert, caKey, err := tlsutil.GenerateCA("test-org", 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seedTransport, _ := createTestTransport(t, caCert, caKey, "seed")
+	ip, port, err := seedTransport.FinalAdvertiseAddr("", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tr, err := New(Config{BindAddr: "127.0.0.1", TLS: &tls.Config{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = tr.Shutdown() })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	seed := net.JoinHostPort(ip.String(), strconv.Itoa(port))
+	_, _, err = tr.Bootstrap(ctx, seed, BootstrapRequest{Token: "s3cret", CACert: caCert})
+	if err == nil {
+		t.Fatal("expected bootstrap to fail against a seed without Bootstrap")
+	}
+	var unsupported *unsupportedProtocolError
+	if IsLabelMismatch(err) || !errors.As(err, &unsupported) {
+		t.Fatalf("expected the seed to refuse the bootstrap protocol, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "seed does not serve bootstrap") {
+		t.Errorf("unclear error: %v", err)
+	}
+
+	for _, bc := range []*BootstrapConfig{
+		{CACert: caCert, CAKey: caKey, Policy: &tlsutil.IssuerPolicy{AllowedNames: []string{"node-*"}}},
+		{Token: "s3cret", CACert: caCert, CAKey: caKey},
+		{Token: "s3cret", CACert: caCert, CAKey: caKey, Policy: &tlsutil.IssuerPolicy{}},
+	} {
+		if _, err := New(Config{BindAddr: "127.0.0.1", TLS: &tls.Config{}, Bootstrap: bc}); err == nil {
+			t.Fatalf("expected error for Bootstrap without a token or a check on names: %+v", bc)
+		}
+	}
+}
+
+func TestBootstrapPolicy(t *testing.T) {
+	caCert, caKey, err := tlsutil.GenerateCA("test-org", 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seedCert, seedKey, err := tlsutil.GenerateNodeCertWithIPs(caCert, caKey, "seed", []net.IP{net.IPv4(127, 0, 0, 1)}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seedConf, err := tlsutil.MutualTLSConfig(seedCert, seedKey, caCert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seedTransport, err := New(Config{
+		BindAddr: "127.0.0.1",
+		TLS:      seedConf,
+		Bootstrap: &BootstrapConfig{
+			Token:  "s3cret",
+			CACert: caCert,
+			CAKey:  caKey,
+			Policy: &tlsutil.IssuerPolicy{AllowedNames: []string{"node-*"}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = seedTransport.Shutdown() })
+
+	tr, err := New(Config{BindAddr: "127.0.0.1", TLS: &tls.Config{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = tr.Shutdown() })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	seed := "seed/" + transporttest.Addr(t, seedTransport)
+	req := BootstrapRequest{Token: "s3cret", CACert: caCert, Cert: tlsutil.NodeCertOptions{NodeID: "seed"}}
+	if _, _, err := tr.Bootstrap(ctx, seed, req); err == nil || !strings.Contains(err.Error(), "not allowed by issuer policy") {
+		t.Fatalf("expected the seed's own name to be refused, got %v", err)
+	}
+	req.Cert.NodeID = "node-1"
+	if _, _, err := tr.Bootstrap(ctx, seed, req); err != nil {
+		t.Fatalf("bootstrap within policy failed: %v", err)
+	}
+}
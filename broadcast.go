@@ -0,0 +1,266 @@
+package memberlistquic
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	metrics "github.com/hashicorp/go-metrics/compat"
+	"github.com/hashicorp/memberlist"
+	"github.com/quic-go/quic-go"
+)
+
+const (
+	// broadcastMagic prefixes broadcast messages sent as packets. Like
+	// channelMagic, memberlist messages never start with it.
+	broadcastMagic byte = 0xf3
+
+	broadcastProtocol = "broadcast/1"
+
+	defaultBroadcastParallelism    = 16
+	defaultBroadcastMaxMessageSize = 1 << 20
+	defaultBroadcastReadTimeout    = 10 * time.Second
+)
+
+// BroadcastConfig configures a Broadcaster.
+type BroadcastConfig struct {
+	// Parallelism is the number of members sent to at once. Defaults
+	// to 16.
+	Parallelism int
+
+	// MaxMessageSize is the largest message in bytes that is sent or
+	// accepted. Defaults to 1 MiB.
+	MaxMessageSize int
+
+	// QueueSize is the buffer size of the Messages channel. Defaults to
+	// the transport's PacketQueueSize.
+	QueueSize int
+
+	// ReadTimeout bounds how long a member waits to receive a broadcast
+	// sent over a stream. Defaults to 10 seconds.
+	ReadTimeout time.Duration
+}
+
+// BroadcastMessage is a message received from a Broadcaster on another
+// member.
+type BroadcastMessage struct {
+	// From describes the certificate the sender authenticated with.
+	From CertificateInfo
+
+	Payload   []byte
+	Timestamp time.Time
+}
+
+// BroadcastResult is the outcome of sending a broadcast to one member.
+type BroadcastResult struct {
+	Node *memberlist.Node
+
+	// Err is nil if the message was sent, or, for acknowledged
+	// broadcasts, queued by the member.
+	Err error
+}
+
+// Broadcaster sends messages directly to every member over the pooled
+// connections, rather than gossiping them, and receives those sent by
+// other members. Create one with Transport.NewBroadcaster.
+type Broadcaster struct {
+	t       *Transport
+	config  BroadcastConfig
+	msgCh   chan *BroadcastMessage
+	dropped atomic.Uint64
+}
+
+// NewBroadcaster enables broadcasts on the transport. Only one
+// Broadcaster may be enabled per transport, and members without one drop
+// broadcasts sent to them.
+func (t *Transport) NewBroadcaster(config BroadcastConfig) (*Broadcaster, error) {
+	if config.Parallelism <= 0 {
+		config.Parallelism = defaultBroadcastParallelism
+	}
+	if config.MaxMessageSize <= 0 {
+		config.MaxMessageSize = defaultBroadcastMaxMessageSize
+	}
+	if config.QueueSize <= 0 {
+		config.QueueSize = t.config.PacketQueueSize
+	}
+	if config.ReadTimeout <= 0 {
+		config.ReadTimeout = defaultBroadcastReadTimeout
+	}
+	b := &Broadcaster{
+		t:      t,
+		config: config,
+		msgCh:  make(chan *BroadcastMessage, config.QueueSize),
+	}
+
+	t.protocolsMu.Lock()
+	defer t.protocolsMu.Unlock()
+	if _, ok := t.protocols[broadcastProtocol]; ok {
+		return nil, errors.New("broadcasts are already enabled on this transport")
+	}
+	t.protocols[broadcastProtocol] = b.serve
+	t.broadcaster.Store(b)
+	return b, nil
+}
+
+// Messages returns the channel of broadcasts received from other members.
+// It must be drained: while it is full, acknowledged broadcasts wait for
+// room and the others are dropped (see Dropped).
+func (b *Broadcaster) Messages() <-chan *BroadcastMessage {
+	return b.msgCh
+}
+
+// Dropped returns the number of unacknowledged broadcasts dropped because
+// the Messages channel was full. Drops are also counted by the
+// memberlist.quic.broadcasts_dropped metric.
+func (b *Broadcaster) Dropped() uint64 {
+	return b.dropped.Load()
+}
+
+// Broadcast sends msg to each of nodes, typically memberlist's Members()
+// without the local node, and returns a result per node in the same
+// order. Peers are verified per Config.ServerName, as for memberlist
+// traffic.
+//
+// Messages that fit in a stream frame are sent as packets, which use a
+// datagram when small enough; a nil error then only means the message
+// was sent. With ack, or for larger messages, each member confirms that
+// it queued the message on its Messages channel. ctx bounds the whole
+// broadcast.
+func (b *Broadcaster) Broadcast(ctx context.Context, nodes []*memberlist.Node, msg []byte, ack bool) []BroadcastResult {
+	results := make([]BroadcastResult, len(nodes))
+	if len(msg) > b.config.MaxMessageSize {
+		err := fmt.Errorf("broadcast of %d bytes exceeds maximum of %d", len(msg), b.config.MaxMessageSize)
+		for i, node := range nodes {
+			results[i] = BroadcastResult{Node: node, Err: err}
+		}
+		return results
+	}
+
+	var packet []byte
+	if !ack && len(msg)+1 <= maxFrameSize {
+		packet = append([]byte{broadcastMagic}, msg...)
+	}
+
+	sem := make(chan struct{}, b.config.Parallelism)
+	var wg sync.WaitGroup
+	for i, node := range nodes {
+		results[i].Node = node
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			results[i].Err = ctx.Err()
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			results[i].Err = b.send(ctx, node, msg, packet)
+		}()
+	}
+	wg.Wait()
+	return results
+}
+
+// send delivers msg to node as packet if set, or over a stream awaiting
+// acknowledgement otherwise.
+func (b *Broadcaster) send(ctx context.Context, node *memberlist.Node, msg, packet []byte) error {
+	addr := memberlist.Address{Addr: node.Address(), Name: node.Name}
+	if packet != nil {
+		conn, err := b.t.pool.GetOrDialName(ctx, addr.Addr, b.t.serverName(addr))
+		if err != nil {
+			return b.t.dialError(addr.Addr, b.t.alpn, err)
+		}
+		_, err = sendDatagram(conn, packet)
+		return err
+	}
+
+	conn, err := b.t.dialProtocol(ctx, addr.Addr, b.t.serverName(addr))
+	if err != nil {
+		return err
+	}
+	stream, err := openProtocolStream(ctx, conn, broadcastProtocol)
+	if err != nil {
+		return err
+	}
+	stream.SetDeadline(time.Time{})
+	stop := context.AfterFunc(ctx, func() {
+		stream.CancelRead(0)
+		stream.CancelWrite(0)
+	})
+	defer stop()
+	if _, err := stream.Write(msg); err != nil {
+		stream.CancelRead(0)
+		return err
+	}
+	stream.Close()
+	if _, err := readResponse(stream); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return fmt.Errorf("broadcast to %s: %w", node.Name, err)
+	}
+	return nil
+}
+
+// serve receives a broadcast sent over a stream within ReadTimeout and
+// acknowledges it once queued.
+func (b *Broadcaster) serve(conn *quic.Conn, stream *quic.Stream) {
+	stream.SetReadDeadline(time.Now().Add(b.config.ReadTimeout))
+	msg, err := io.ReadAll(io.LimitReader(stream, int64(b.config.MaxMessageSize)+1))
+	if err != nil {
+		stream.CancelRead(0)
+		stream.CancelWrite(0)
+		return
+	}
+	if len(msg) > b.config.MaxMessageSize {
+		stream.CancelRead(0)
+		err = fmt.Errorf("broadcast exceeds maximum of %d bytes", b.config.MaxMessageSize)
+	} else {
+		ctx, cancel := context.WithCancel(stream.Context())
+		defer cancel()
+		stop := context.AfterFunc(b.t.dialContext(), cancel)
+		defer stop()
+		err = b.deliver(ctx, conn, msg)
+	}
+	if err := writeResponse(stream, nil, err); err != nil {
+		stream.CancelWrite(0)
+		return
+	}
+	stream.Close()
+}
+
+// deliver queues a message received on conn, waiting for room until ctx
+// is done.
+func (b *Broadcaster) deliver(ctx context.Context, conn *quic.Conn, msg []byte) error {
+	select {
+	case b.msgCh <- newBroadcastMessage(conn, msg):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// deliverPacket queues an unacknowledged message received on conn, or
+// drops it if the queue is full. It runs on the connection's receive
+// loop, which must not wait on the application.
+func (b *Broadcaster) deliverPacket(conn *quic.Conn, msg []byte) {
+	select {
+	case b.msgCh <- newBroadcastMessage(conn, msg):
+	default:
+		b.dropped.Add(1)
+		metrics.IncrCounterWithLabels([]string{"memberlist", "quic", "broadcasts_dropped"}, 1, b.t.config.MetricLabels)
+	}
+}
+
+func newBroadcastMessage(conn *quic.Conn, msg []byte) *BroadcastMessage {
+	return &BroadcastMessage{
+		From:      peerInfo(conn),
+		Payload:   msg,
+		Timestamp: time.Now(),
+	}
+}
@@ -0,0 +1,200 @@
+package memberlistquic
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/memberlist"
+	"github.com/wjordan/memberlist-quic/tlsutil"
+)
+
+func TestBroadcast(t *testing.T) {
+	caCert, caKey, err := tlsutil.GenerateCA("test-org", 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var lists []*memberlist.Memberlist
+	var broadcasters []*Broadcaster
+	for i := range 4 {
+		transport, cfg := createTestTransport(t, caCert, caKey, fmt.Sprintf("node-%d", i+1))
+		// The last node does not enable broadcasts
+		if i < 3 {
+			b, err := transport.NewBroadcaster(BroadcastConfig{Parallelism: 2})
+			if err != nil {
+				t.Fatal(err)
+			}
+			broadcasters = append(broadcasters, b)
+		}
+		ml, err := memberlist.Create(cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { _ = ml.Shutdown() })
+		// Joining every member syncs state with each of them, rather than
+		// relying on gossip reaching all of them
+		var seeds []string
+		for _, other := range lists {
+			seeds = append(seeds, advertiseAddr(t, other))
+		}
+		if len(seeds) > 0 {
+			if _, err := ml.Join(seeds); err != nil {
+				t.Fatalf("join failed: %v", err)
+			}
+		}
+		lists = append(lists, ml)
+	}
+	waitForMembers(t, 4, lists...)
+
+	var nodes []*memberlist.Node
+	for _, node := range lists[0].Members() {
+		if node.Name != lists[0].LocalNode().Name {
+			nodes = append(nodes, node)
+		}
+	}
+
+	receive := func(b *Broadcaster, want []byte) {
+		t.Helper()
+		select {
+		case msg := <-b.Messages():
+			if msg.From.NodeID != "node-1" {
+				t.Errorf("expected broadcast from node-1, got %q", msg.From.NodeID)
+			}
+			if !bytes.Equal(msg.Payload, want) {
+				t.Errorf("received %d bytes, want %d", len(msg.Payload), len(want))
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for broadcast")
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	for _, tc := range []struct {
+		name string
+		msg  []byte
+		ack  bool
+	}{
+		{"datagram", []byte("small"), false},
+		{"stream", bytes.Repeat([]byte("x"), 200*1024), false},
+		{"acknowledged", []byte("acked"), true},
+	} {
+		res := broadcasters[0].Broadcast(ctx, nodes, tc.msg, tc.ack)
+		if len(res) != len(nodes) {
+			t.Fatalf("%s: expected %d results, got %d", tc.name, len(nodes), len(res))
+		}
+		for i, r := range res {
+			if r.Node != nodes[i] {
+				t.Fatalf("%s: results out of order", tc.name)
+			}
+			// Only acknowledged broadcasts report that node-4 dropped them
+			acked := tc.ack || len(tc.msg) > maxFrameSize
+			if r.Node.Name == "node-4" && acked {
+				if r.Err == nil {
+					t.Errorf("%s: expected error from node without a broadcaster", tc.name)
+				}
+				continue
+			}
+			if r.Err != nil {
+				t.Errorf("%s: broadcast to %s failed: %v", tc.name, r.Node.Name, r.Err)
+			}
+		}
+		for _, b := range broadcasters[1:] {
+			receive(b, tc.msg)
+		}
+	}
+
+	for _, r := range broadcasters[0].Broadcast(ctx, nodes, make([]byte, 2<<20), true) {
+		if r.Err == nil {
+			t.Fatalf("expected error broadcasting to %s beyond the size limit", r.Node.Name)
+		}
+	}
+}
+
+func TestBroadcastQueueFull(t *testing.T) {
+	caCert, caKey, err := tlsutil.GenerateCA("test-org", 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr1, _ := createTestTransport(t, caCert, caKey, "node-1")
+	tr2, _ := createTestTransport(t, caCert, caKey, "node-2")
+	b1, err := tr1.NewBroadcaster(BroadcastConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b2, err := tr2.NewBroadcaster(BroadcastConfig{QueueSize: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ip, port, err := tr2.FinalAdvertiseAddr("", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	node := &memberlist.Node{Name: "node-2", Addr: ip, Port: uint16(port)}
+
+	// Nobody drains node-2's queue, so all but the first are dropped
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for i := range 3 {
+		if r := b1.Broadcast(ctx, []*memberlist.Node{node}, []byte{byte(i)}, false); r[0].Err != nil {
+			t.Fatal(r[0].Err)
+		}
+	}
+
+	// The connection's receive loop keeps delivering memberlist packets
+	if _, err := tr1.WriteTo([]byte("ping"), node.Address()); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case packet := <-tr2.PacketCh():
+		if string(packet.Buf) != "ping" {
+			t.Fatalf("unexpected packet %q", packet.Buf)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("packet delivery blocked by a full broadcast queue")
+	}
+	if n := b2.Dropped(); n != 2 {
+		t.Fatalf("expected 2 dropped broadcasts, got %d", n)
+	}
+	if msg := <-b2.Messages(); msg.Payload[0] != 0 {
+		t.Fatalf("expected the first broadcast to be queued, got %v", msg.Payload)
+	}
+}
+
+func TestBroadcastReadTimeout(t *testing.T) {
+	caCert, caKey, err := tlsutil.GenerateCA("test-org", 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr1, _ := createTestTransport(t, caCert, caKey, "node-1")
+	tr2, _ := createTestTransport(t, caCert, caKey, "node-2")
+	if _, err := tr2.NewBroadcaster(BroadcastConfig{ReadTimeout: 200 * time.Millisecond}); err != nil {
+		t.Fatal(err)
+	}
+	ip, port, err := tr2.FinalAdvertiseAddr("", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A sender that never finishes its message is reset by the receiver
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, err := tr1.dialProtocol(ctx, (&net.UDPAddr{IP: ip, Port: port}).String(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream, err := openProtocolStream(ctx, conn, broadcastProtocol)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stream.Write([]byte("partial")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readResponse(stream); err == nil || ctx.Err() != nil {
+		t.Fatalf("expected the stream to be reset after the read timeout, got %v", err)
+	}
+}
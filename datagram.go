@@ -3,11 +3,16 @@ package memberlistquic
 import (
 	"encoding/binary"
 	"errors"
+	"fmt"
+	"io"
 	"time"
 
 	"github.com/quic-go/quic-go"
 )
 
+// maxFrameSize is the largest payload accepted in a stream frame.
+const maxFrameSize = 65536
+
 // sendDatagram sends a packet via QUIC datagram if possible, falling back
 // to a unidirectional stream if the payload exceeds the datagram MTU or
 // if the peer doesn't support datagrams.
@@ -31,7 +36,6 @@ func sendDatagram(conn *quic.Conn, payload []byte) (time.Time, error) {
 
 // sendViaStream sends a packet-like message over a unidirectional stream.
 // Used as fallback when datagrams are unavailable or payload exceeds MTU.
-// Format: [4B length BE][payload]
 func sendViaStream(conn *quic.Conn, payload []byte) error {
 	stream, err := conn.OpenUniStream()
 	if err != nil {
@@ -39,11 +43,38 @@ func sendViaStream(conn *quic.Conn, payload []byte) error {
 	}
 	defer stream.Close()
 
+	return writeFrame(stream, payload)
+}
+
+// writeFrame writes payload as a single frame.
+// Format: [4B length BE][payload]
+func writeFrame(w io.Writer, payload []byte) error {
+	if len(payload) > maxFrameSize {
+		return fmt.Errorf("frame of %d bytes exceeds maximum of %d", len(payload), maxFrameSize)
+	}
 	var hdr [4]byte
 	binary.BigEndian.PutUint32(hdr[:], uint32(len(payload)))
-	if _, err := stream.Write(hdr[:]); err != nil {
+	if _, err := w.Write(hdr[:]); err != nil {
 		return err
 	}
-	_, err = stream.Write(payload)
+	_, err := w.Write(payload)
 	return err
 }
+
+// readFrame reads a single frame written by writeFrame. The length is
+// validated before allocating the payload buffer.
+func readFrame(r io.Reader) ([]byte, error) {
+	var hdr [4]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return nil, err
+	}
+	size := binary.BigEndian.Uint32(hdr[:])
+	if size > maxFrameSize {
+		return nil, fmt.Errorf("frame of %d bytes exceeds maximum of %d", size, maxFrameSize)
+	}
+	buf := make([]byte, size)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
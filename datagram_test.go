@@ -0,0 +1,73 @@
+package memberlistquic
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"testing"
+)
+
+// maxFuzzInput bounds how much of a fuzz input the frame reader may
+// consume, standing in for a peer that stops sending mid-frame.
+const maxFuzzInput = maxFrameSize + 8
+
+func FuzzReadFrame(f *testing.F) {
+	f.Add([]byte{0, 0, 0, 0})
+	f.Add([]byte{0, 0, 0, 4, 'p', 'i', 'n', 'g'})
+	f.Add([]byte{0, 0, 0, 8, 's', 'h', 'o', 'r', 't'})
+	f.Add([]byte{0xff, 0xff, 0xff, 0xff})
+	f.Add([]byte{0, 1, 0, 1})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		r := io.LimitReader(bytes.NewReader(data), maxFuzzInput)
+		buf, err := readFrame(r)
+		if err != nil {
+			return
+		}
+		if len(buf) > maxFrameSize {
+			t.Fatalf("accepted frame of %d bytes", len(buf))
+		}
+		size := binary.BigEndian.Uint32(data[:4])
+		if int(size) != len(buf) || !bytes.Equal(buf, data[4:4+size]) {
+			t.Fatalf("decoded payload does not match input")
+		}
+	})
+}
+
+func FuzzFrameRoundTrip(f *testing.F) {
+	f.Add([]byte{})
+	f.Add([]byte("ping"))
+	f.Add(bytes.Repeat([]byte{0xab}, 1500))
+
+	f.Fuzz(func(t *testing.T, payload []byte) {
+		var buf bytes.Buffer
+		if err := writeFrame(&buf, payload); err != nil {
+			if len(payload) <= maxFrameSize {
+				t.Fatalf("writeFrame rejected %d byte payload: %v", len(payload), err)
+			}
+			return
+		}
+		got, err := readFrame(&buf)
+		if err != nil {
+			t.Fatalf("readFrame: %v", err)
+		}
+		if !bytes.Equal(got, payload) {
+			t.Fatal("round trip mismatch")
+		}
+		if buf.Len() != 0 {
+			t.Fatalf("%d trailing bytes after frame", buf.Len())
+		}
+	})
+}
+
+func TestFrameTooLarge(t *testing.T) {
+	if err := writeFrame(io.Discard, make([]byte, maxFrameSize+1)); err == nil {
+		t.Fatal("expected error writing oversized frame")
+	}
+
+	var hdr [4]byte
+	binary.BigEndian.PutUint32(hdr[:], maxFrameSize+1)
+	if _, err := readFrame(bytes.NewReader(hdr[:])); err == nil {
+		t.Fatal("expected error reading oversized frame")
+	}
+}
@@ -0,0 +1,172 @@
+package memberlistquic
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"slices"
+	"time"
+
+	metrics "github.com/hashicorp/go-metrics/compat"
+	"github.com/quic-go/quic-go"
+	"github.com/wjordan/memberlist-quic/tlsutil"
+)
+
+// CertificateInfo describes the certificate chain presented by the local
+// node or a connected peer.
+type CertificateInfo struct {
+	// Addr is the peer's address, or empty for the local certificate.
+	Addr string
+
+	// NodeID is the certificate's SPIFFE ID or Common Name, if any (see
+	// tlsutil.NodeIDFromCert).
+	NodeID string
+
+	// Leaf is the node certificate, and Chain its certificate chain,
+	// leaf first.
+	Leaf  *x509.Certificate
+	Chain []*x509.Certificate
+
+	// Expiry is the earliest NotAfter in the chain: the node can no
+	// longer authenticate once any certificate in its chain expires.
+	Expiry time.Time
+}
+
+func newCertificateInfo(addr string, chain []*x509.Certificate) CertificateInfo {
+	info := CertificateInfo{Addr: addr, Leaf: chain[0], Chain: chain, Expiry: chain[0].NotAfter}
+	info.NodeID, _ = tlsutil.NodeIDFromCert(chain[0])
+	for _, cert := range chain[1:] {
+		if cert.NotAfter.Before(info.Expiry) {
+			info.Expiry = cert.NotAfter
+		}
+	}
+	return info
+}
+
+// LocalCertificate returns the certificate the transport currently
+// presents to peers.
+func (t *Transport) LocalCertificate() (CertificateInfo, error) {
+	conf := t.tlsConfig.Load()
+	var cert *tls.Certificate
+	var err error
+	switch {
+	case len(conf.Certificates) > 0:
+		cert = &conf.Certificates[0]
+	case conf.GetCertificate != nil:
+		cert, err = conf.GetCertificate(&tls.ClientHelloInfo{})
+	case conf.GetClientCertificate != nil:
+		cert, err = conf.GetClientCertificate(&tls.CertificateRequestInfo{})
+	}
+	if err != nil {
+		return CertificateInfo{}, err
+	}
+	if cert == nil || len(cert.Certificate) == 0 {
+		return CertificateInfo{}, errors.New("no local certificate configured")
+	}
+
+	chain := make([]*x509.Certificate, len(cert.Certificate))
+	for i, der := range cert.Certificate {
+		if chain[i], err = x509.ParseCertificate(der); err != nil {
+			return CertificateInfo{}, err
+		}
+	}
+	return newCertificateInfo("", chain), nil
+}
+
+// PeerCertificates returns the certificates of all peers with a pooled
+// connection, once per connection. The chain is the verified chain up to the trusted root when
+// available, otherwise the certificates the peer presented.
+func (t *Transport) PeerCertificates() []CertificateInfo {
+	var infos []CertificateInfo
+	t.pool.rangeAll(func(addr string, conn *quic.Conn) {
+		if chain := peerChain(conn.ConnectionState().TLS); len(chain) > 0 {
+			infos = append(infos, newCertificateInfo(addr, chain))
+		}
+	})
+	return infos
+}
+
+// peerChain returns the peer's verified certificate chain, or the chain
+// it presented if it was verified by other means, such as pinning.
+func peerChain(state tls.ConnectionState) []*x509.Certificate {
+	if len(state.VerifiedChains) > 0 {
+		return state.VerifiedChains[0]
+	}
+	return state.PeerCertificates
+}
+
+// peerInfo describes the certificate the peer on conn authenticated with.
+func peerInfo(conn *quic.Conn) CertificateInfo {
+	addr := conn.RemoteAddr().String()
+	if chain := peerChain(conn.ConnectionState().TLS); len(chain) > 0 {
+		return newCertificateInfo(addr, chain)
+	}
+	return CertificateInfo{Addr: addr}
+}
+
+// certMonitor periodically checks local and peer certificate expiry.
+func (t *Transport) certMonitor() {
+	defer t.wg.Done()
+	ticker := time.NewTicker(t.config.CertCheckInterval)
+	defer ticker.Stop()
+
+	warned := make(map[string]bool)
+	for {
+		t.checkCertificates(warned)
+		select {
+		case <-ticker.C:
+		case <-t.shutdownCh:
+			return
+		}
+	}
+}
+
+// checkCertificates emits expiry metrics and logs a warning the first time
+// each certificate chain is seen within the warning threshold. warned
+// tracks the chains already warned about.
+func (t *Transport) checkCertificates(warned map[string]bool) {
+	now := time.Now()
+	labels := t.config.MetricLabels
+	seen := make(map[string]bool)
+	expiring := 0
+
+	check := func(info CertificateInfo, desc string) {
+		ttl := info.Expiry.Sub(now)
+		if ttl > t.config.CertExpiryWarning {
+			return
+		}
+		expiring++
+		key := info.Addr + "/" + info.Leaf.SerialNumber.String()
+		seen[key] = true
+		if warned[key] {
+			return
+		}
+		warned[key] = true
+		if ttl <= 0 {
+			t.logger.Printf("[ERR] memberlist-quic: %s expired at %s", desc, info.Expiry.Format(time.RFC3339))
+		} else {
+			t.logger.Printf("[WARN] memberlist-quic: %s expires in %s (at %s)", desc, ttl.Round(time.Second), info.Expiry.Format(time.RFC3339))
+		}
+	}
+
+	if len(t.tlsConfig.Load().Certificates) > 0 || t.config.CertCheckCallbacks {
+		if local, err := t.LocalCertificate(); err == nil {
+			metrics.SetGaugeWithLabels([]string{"memberlist", "quic", "local_cert_ttl"}, float32(local.Expiry.Sub(now).Seconds()), labels)
+			check(local, "local certificate")
+		}
+	}
+	for _, peer := range t.PeerCertificates() {
+		peerLabels := append(slices.Clip(labels), metrics.Label{Name: "peer", Value: peer.NodeID})
+		metrics.SetGaugeWithLabels([]string{"memberlist", "quic", "peer_cert_ttl"}, float32(peer.Expiry.Sub(now).Seconds()), peerLabels)
+		check(peer, "certificate of peer "+peer.NodeID+" at "+peer.Addr)
+	}
+	metrics.SetGaugeWithLabels([]string{"memberlist", "quic", "certs_expiring"}, float32(expiring), labels)
+
+	// Forget chains that were rotated away or disconnected, so a
+	// reappearing chain is warned about again
+	for key := range warned {
+		if !seen[key] {
+			delete(warned, key)
+		}
+	}
+}
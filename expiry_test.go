@@ -0,0 +1,204 @@
+package memberlistquic
+
+import (
+	"bytes"
+	"crypto/tls"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	metrics "github.com/hashicorp/go-metrics/compat"
+	"github.com/hashicorp/memberlist"
+	"github.com/wjordan/memberlist-quic/tlsutil"
+)
+
+// syncBuffer is a bytes.Buffer safe for use as concurrent log output.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestCertExpiry(t *testing.T) {
+	sink := metrics.NewInmemSink(time.Minute, time.Minute)
+	conf := metrics.DefaultConfig("test")
+	conf.EnableHostname = false
+	conf.EnableRuntimeMetrics = false
+	if _, err := metrics.NewGlobal(conf, sink); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _, _ = metrics.NewGlobal(conf, &metrics.BlackholeSink{}) })
+
+	caCert, caKey, err := tlsutil.GenerateCA("test-org", 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// node-1 holds a certificate expiring within the warning threshold
+	var logs syncBuffer
+	newTransport := func(name string, validity time.Duration) *Transport {
+		cert, key, err := tlsutil.GenerateNodeCertWithIPs(caCert, caKey, name, []net.IP{net.IPv4(127, 0, 0, 1)}, validity)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tlsConf, err := tlsutil.MutualTLSConfig(cert, key, caCert)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tr, err := New(Config{
+			BindAddr:          "127.0.0.1",
+			TLS:               tlsConf,
+			Logger:            log.New(&logs, name+" ", 0),
+			CertExpiryWarning: 2 * time.Hour,
+			CertCheckInterval: 50 * time.Millisecond,
+			MetricLabels:      []metrics.Label{{Name: "node", Value: name}},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { _ = tr.Shutdown() })
+		return tr
+	}
+	expiring := newTransport("node-1", time.Hour)
+	healthy := newTransport("node-2", 24*time.Hour)
+
+	local, err := expiring.LocalCertificate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if local.NodeID != "node-1" || time.Until(local.Expiry) > time.Hour {
+		t.Fatalf("unexpected local certificate %s expiring %s", local.NodeID, local.Expiry)
+	}
+
+	// Connect the healthy node to the expiring one
+	ip, port, err := expiring.FinalAdvertiseAddr("", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := memberlist.Address{Addr: net.JoinHostPort(ip.String(), strconv.Itoa(port))}
+	if _, err := healthy.WriteToAddress([]byte("ping"), addr); err != nil {
+		t.Fatal(err)
+	}
+	peers := healthy.PeerCertificates()
+	if len(peers) != 1 || peers[0].NodeID != "node-1" || !peers[0].Expiry.Equal(local.Expiry) {
+		t.Fatalf("unexpected peer certificates %+v", peers)
+	}
+	if len(peers[0].Chain) != 2 {
+		t.Fatalf("expected verified chain with CA, got %d certificates", len(peers[0].Chain))
+	}
+
+	want := []string{
+		"node-1 [WARN] memberlist-quic: local certificate expires in",
+		"node-2 [WARN] memberlist-quic: certificate of peer node-1",
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		out := logs.String()
+		missing := false
+		for _, w := range want {
+			missing = missing || !strings.Contains(out, w)
+		}
+		if !missing {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("missing expiry warnings in log:\n%s", out)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	// Each certificate is warned about once, not on every check
+	time.Sleep(200 * time.Millisecond)
+	out := logs.String()
+	if n := strings.Count(out, "node-1 [WARN] memberlist-quic: local certificate"); n != 1 {
+		t.Errorf("expected 1 local certificate warning, got %d:\n%s", n, out)
+	}
+	if strings.Contains(out, "node-2 [WARN] memberlist-quic: local certificate") {
+		t.Errorf("unexpected warning for healthy certificate:\n%s", out)
+	}
+
+	gauges := sink.Data()[0].Gauges
+	expect := map[string]func(float32) bool{
+		"test.memberlist.quic.local_cert_ttl;node=node-1":            func(v float32) bool { return v > 0 && v <= 3600 },
+		"test.memberlist.quic.peer_cert_ttl;node=node-2;peer=node-1": func(v float32) bool { return v > 0 && v <= 3600 },
+		"test.memberlist.quic.certs_expiring;node=node-1":            func(v float32) bool { return v == 1 },
+		"test.memberlist.quic.certs_expiring;node=node-2":            func(v float32) bool { return v == 1 },
+		"test.memberlist.quic.local_cert_ttl;node=node-2":            func(v float32) bool { return v > 3600 },
+	}
+	for key, ok := range expect {
+		g, found := gauges[key]
+		if !found {
+			t.Errorf("missing gauge %s", key)
+			continue
+		}
+		if !ok(g.Value) {
+			t.Errorf("unexpected value %v for gauge %s", g.Value, key)
+		}
+	}
+}
+
+func TestCertExpiryCallbacks(t *testing.T) {
+	caCert, caKey, err := tlsutil.GenerateCA("test-org", 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, key, err := tlsutil.GenerateNodeCertWithIPs(caCert, caKey, "node-1", []net.IP{net.IPv4(127, 0, 0, 1)}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tlsConf, err := tlsutil.MutualTLSConfig(cert, key, caCert)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The certificate is only served by GetCertificate, which the monitor
+	// calls only when allowed to
+	for _, callbacks := range []bool{false, true} {
+		var logs syncBuffer
+		var calls atomic.Int32
+		conf := tlsConf.Clone()
+		local := conf.Certificates[0]
+		conf.Certificates = nil
+		conf.GetCertificate = func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			calls.Add(1)
+			return &local, nil
+		}
+		tr, err := New(Config{
+			BindAddr:           "127.0.0.1",
+			TLS:                conf,
+			Logger:             log.New(&logs, "", 0),
+			CertExpiryWarning:  2 * time.Hour,
+			CertCheckInterval:  20 * time.Millisecond,
+			CertCheckCallbacks: callbacks,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(100 * time.Millisecond)
+		_ = tr.Shutdown()
+
+		warned := strings.Contains(logs.String(), "local certificate expires")
+		if callbacks && (calls.Load() == 0 || !warned) {
+			t.Errorf("expected the opted-in monitor to check the certificate, got %d calls:\n%s", calls.Load(), logs.String())
+		}
+		if !callbacks && (calls.Load() != 0 || warned) {
+			t.Errorf("expected the monitor not to call GetCertificate, got %d calls:\n%s", calls.Load(), logs.String())
+		}
+	}
+}
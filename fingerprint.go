@@ -0,0 +1,89 @@
+package memberlistquic
+
+import (
+	"bytes"
+
+	"github.com/hashicorp/memberlist"
+	"github.com/wjordan/memberlist-quic/tlsutil"
+)
+
+// fingerprintMagic prefixes node metadata published by FingerprintDelegate.
+const fingerprintMagic = "\xf1fp"
+
+// FingerprintDelegate is a memberlist.Delegate that publishes the node's
+// public key fingerprint (see tlsutil.Fingerprint) in its node metadata,
+// ahead of the metadata of the wrapped Delegate, so that members can learn
+// each other's fingerprints for pinning. Use ParseFingerprintMeta to read
+// it from a memberlist.Node.
+type FingerprintDelegate struct {
+	Fingerprint string
+
+	// Delegate, if set, receives all other delegate calls and provides the
+	// rest of the node metadata.
+	Delegate memberlist.Delegate
+}
+
+// FingerprintMeta encodes a fingerprint ahead of meta, as published by
+// FingerprintDelegate.
+func FingerprintMeta(fingerprint string, meta []byte) []byte {
+	buf := make([]byte, 0, len(fingerprintMagic)+1+len(fingerprint)+len(meta))
+	buf = append(buf, fingerprintMagic...)
+	buf = append(buf, byte(len(fingerprint)))
+	buf = append(buf, fingerprint...)
+	return append(buf, meta...)
+}
+
+// ParseFingerprintMeta splits node metadata published by
+// FingerprintDelegate into the fingerprint and the wrapped delegate's
+// metadata. ok is false if the metadata carries no valid fingerprint, in
+// which case meta is returned unchanged.
+func ParseFingerprintMeta(nodeMeta []byte) (fingerprint string, meta []byte, ok bool) {
+	rest, found := bytes.CutPrefix(nodeMeta, []byte(fingerprintMagic))
+	if !found || len(rest) == 0 || int(rest[0]) > len(rest)-1 {
+		return "", nodeMeta, false
+	}
+	fingerprint = string(rest[1 : 1+rest[0]])
+	if tlsutil.ValidateFingerprint(fingerprint) != nil {
+		return "", nodeMeta, false
+	}
+	return fingerprint, rest[1+rest[0]:], true
+}
+
+// NodeMeta implements memberlist.Delegate.
+func (d *FingerprintDelegate) NodeMeta(limit int) []byte {
+	var meta []byte
+	if d.Delegate != nil {
+		meta = d.Delegate.NodeMeta(limit - len(fingerprintMagic) - 1 - len(d.Fingerprint))
+	}
+	return FingerprintMeta(d.Fingerprint, meta)
+}
+
+// NotifyMsg implements memberlist.Delegate.
+func (d *FingerprintDelegate) NotifyMsg(msg []byte) {
+	if d.Delegate != nil {
+		d.Delegate.NotifyMsg(msg)
+	}
+}
+
+// GetBroadcasts implements memberlist.Delegate.
+func (d *FingerprintDelegate) GetBroadcasts(overhead, limit int) [][]byte {
+	if d.Delegate == nil {
+		return nil
+	}
+	return d.Delegate.GetBroadcasts(overhead, limit)
+}
+
+// LocalState implements memberlist.Delegate.
+func (d *FingerprintDelegate) LocalState(join bool) []byte {
+	if d.Delegate == nil {
+		return nil
+	}
+	return d.Delegate.LocalState(join)
+}
+
+// MergeRemoteState implements memberlist.Delegate.
+func (d *FingerprintDelegate) MergeRemoteState(buf []byte, join bool) {
+	if d.Delegate != nil {
+		d.Delegate.MergeRemoteState(buf, join)
+	}
+}
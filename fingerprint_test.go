@@ -0,0 +1,142 @@
+package memberlistquic
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/memberlist"
+	"github.com/wjordan/memberlist-quic/tlsutil"
+	"github.com/wjordan/memberlist-quic/transporttest"
+)
+
+func TestPinnedCluster(t *testing.T) {
+	dir := t.TempDir()
+	newNode := func(name string) (*memberlist.Memberlist, string) {
+		cert, key, err := tlsutil.GenerateSelfSignedCert(tlsutil.NodeCertOptions{NodeID: name, Validity: time.Hour})
+		if err != nil {
+			t.Fatal(err)
+		}
+		fp, err := tlsutil.FingerprintPEM(cert)
+		if err != nil {
+			t.Fatal(err)
+		}
+		peers, err := tlsutil.LoadKnownPeers(filepath.Join(dir, name+".known_peers"), true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		conf, err := tlsutil.PinnedConfig(cert, key, peers)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tr, err := New(Config{BindAddr: "127.0.0.1", TLS: conf, KnownPeers: peers})
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { _ = tr.Shutdown() })
+
+		cfg := memberlist.DefaultLANConfig()
+		cfg.Name = name
+		cfg.BindAddr = "127.0.0.1"
+		cfg.BindPort = 0
+		cfg.AdvertisePort = 0
+		cfg.Transport = tr
+		cfg.Delegate = &FingerprintDelegate{Fingerprint: fp}
+		cfg.LogOutput = io.Discard
+		ml, err := memberlist.Create(cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { _ = ml.Shutdown() })
+		return ml, fp
+	}
+
+	lists := make([]*memberlist.Memberlist, 3)
+	fps := make(map[string]string)
+	for i := range lists {
+		name := fmt.Sprintf("node-%d", i+1)
+		lists[i], fps[name] = newNode(name)
+	}
+	seed := advertiseAddr(t, lists[0])
+	for _, ml := range lists[1:] {
+		if _, err := ml.Join([]string{seed}); err != nil {
+			t.Fatalf("join failed: %v", err)
+		}
+	}
+	waitForMembers(t, 3, lists...)
+
+	// Members publish their fingerprints in their metadata
+	for _, node := range lists[0].Members() {
+		fp, meta, ok := ParseFingerprintMeta(node.Meta)
+		if !ok || fp != fps[node.Name] || len(meta) != 0 {
+			t.Errorf("node %s: unexpected metadata fingerprint %q, ok=%v", node.Name, fp, ok)
+		}
+	}
+
+	// node-1 has pinned node-2's key on first use, so a new key for the
+	// name is rejected
+	impostor, _ := newNode("node-2")
+	if _, err := impostor.Join([]string{seed}); err == nil {
+		t.Fatal("expected join with a changed key to fail")
+	}
+}
+
+func TestPinnedServerName(t *testing.T) {
+	certs := make(map[string][]byte)
+	keys := make(map[string][]byte)
+	pins := make(map[string]string)
+	for _, name := range []string{"node-a", "node-b"} {
+		cert, key, err := tlsutil.GenerateSelfSignedCert(tlsutil.NodeCertOptions{NodeID: name, Validity: time.Hour})
+		if err != nil {
+			t.Fatal(err)
+		}
+		fp, err := tlsutil.FingerprintPEM(cert)
+		if err != nil {
+			t.Fatal(err)
+		}
+		certs[name], keys[name], pins[name] = cert, key, fp
+	}
+	newTransport := func(name string) *Transport {
+		peers, err := tlsutil.NewKnownPeers(pins)
+		if err != nil {
+			t.Fatal(err)
+		}
+		conf, err := tlsutil.PinnedConfig(certs[name], keys[name], peers)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tr, err := New(Config{BindAddr: "127.0.0.1", TLS: conf, ServerName: NodeNameServerName})
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { _ = tr.Shutdown() })
+		return tr
+	}
+	trA := newTransport("node-a")
+	trB := newTransport("node-b")
+	addrB := transporttest.Addr(t, trB)
+
+	// node-b is pinned, but not as node-a, so even the first send fails
+	if _, err := trA.WriteToAddress([]byte("ping"), memberlist.Address{Addr: addrB, Name: "node-a"}); err == nil {
+		t.Fatal("expected send to node-b as node-a to fail")
+	}
+	if _, err := trA.WriteToAddress([]byte("ping"), memberlist.Address{Addr: addrB, Name: "node-b"}); err != nil {
+		t.Fatalf("send to node-b: %v", err)
+	}
+}
+
+func TestParseFingerprintMeta(t *testing.T) {
+	fp := "SHA256:" + "47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU"
+	meta := FingerprintMeta(fp, []byte("app"))
+	got, rest, ok := ParseFingerprintMeta(meta)
+	if !ok || got != fp || string(rest) != "app" {
+		t.Fatalf("unexpected parse %q %q %v", got, rest, ok)
+	}
+	for _, bad := range [][]byte{nil, []byte("app"), []byte(fingerprintMagic), append([]byte(fingerprintMagic), 200, 'x'), FingerprintMeta("SHA256:short", nil)} {
+		if _, rest, ok := ParseFingerprintMeta(bad); ok || string(rest) != string(bad) {
+			t.Errorf("expected %q not to parse", bad)
+		}
+	}
+}
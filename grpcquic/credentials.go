@@ -0,0 +1,88 @@
+// Package grpcquic provides gRPC transport credentials for running gRPC
+// between cluster members over memberlistquic streams, from
+// Transport.Listen on the server and Transport.StreamDialer on the client:
+//
+//	ln, err := transport.Listen("grpc")
+//	server := grpc.NewServer(grpc.Creds(grpcquic.NewCredentials()))
+//	go server.Serve(ln)
+//
+//	conn, err := grpc.NewClient("passthrough:///10.0.0.2:7946",
+//		grpc.WithContextDialer(transport.StreamDialer("grpc")),
+//		grpc.WithTransportCredentials(grpcquic.NewCredentials()))
+//
+// The streams are already encrypted and mutually authenticated by the
+// QUIC connection they belong to, so the credentials perform no handshake
+// of their own. They report the connection's TLS state as a
+// credentials.TLSInfo, so handlers find the peer's certificates with
+// peer.FromContext as they would with gRPC's own TLS credentials.
+//
+// It is a separate module so that the transport does not depend on gRPC.
+package grpcquic
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+
+	"github.com/wjordan/memberlist-quic/tlsutil"
+	"google.golang.org/grpc/credentials"
+)
+
+// stateConn is implemented by connections from Transport.Listen and
+// Transport.StreamDialer.
+type stateConn interface {
+	ConnectionState() tls.ConnectionState
+}
+
+type quicCredentials struct{}
+
+// NewCredentials returns transport credentials for connections from a
+// memberlistquic Transport. Handshakes fail for any other connection, so
+// gRPC cannot fall back to plaintext.
+func NewCredentials() credentials.TransportCredentials {
+	return quicCredentials{}
+}
+
+func (c quicCredentials) ClientHandshake(_ context.Context, _ string, conn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	return c.handshake(conn)
+}
+
+func (c quicCredentials) ServerHandshake(conn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	return c.handshake(conn)
+}
+
+func (quicCredentials) handshake(conn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	sc, ok := conn.(stateConn)
+	if !ok {
+		return nil, nil, errors.New("grpcquic: connection is not a memberlistquic stream")
+	}
+	state := sc.ConnectionState()
+	if !state.HandshakeComplete {
+		return nil, nil, errors.New("grpcquic: TLS handshake not complete")
+	}
+	info := credentials.TLSInfo{
+		State:          state,
+		CommonAuthInfo: credentials.CommonAuthInfo{SecurityLevel: credentials.PrivacyAndIntegrity},
+	}
+	if len(state.PeerCertificates) > 0 {
+		if id, err := tlsutil.SPIFFEID(state.PeerCertificates[0]); err == nil {
+			info.SPIFFEID = id
+		}
+	}
+	return conn, info, nil
+}
+
+func (quicCredentials) Info() credentials.ProtocolInfo {
+	return credentials.ProtocolInfo{SecurityProtocol: "tls"}
+}
+
+func (c quicCredentials) Clone() credentials.TransportCredentials {
+	return c
+}
+
+// OverrideServerName does nothing: the peer's certificate is verified by
+// the transport when the QUIC connection is established.
+func (quicCredentials) OverrideServerName(string) error {
+	return nil
+}
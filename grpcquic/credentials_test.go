@@ -0,0 +1,115 @@
+package grpcquic
+
+import (
+	"context"
+	"io"
+	"log"
+	"net"
+	"testing"
+	"time"
+
+	memberlistquic "github.com/wjordan/memberlist-quic"
+	"github.com/wjordan/memberlist-quic/tlsutil"
+	"github.com/wjordan/memberlist-quic/transporttest"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// peerHealth is a health service answering with the caller's node name,
+// as read from its certificate.
+type peerHealth struct {
+	grpc_health_v1.UnimplementedHealthServer
+}
+
+func (peerHealth) Check(ctx context.Context, _ *grpc_health_v1.HealthCheckRequest) (*grpc_health_v1.HealthCheckResponse, error) {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return nil, status.Error(codes.Unauthenticated, "no peer")
+	}
+	info, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(info.State.PeerCertificates) == 0 {
+		return nil, status.Errorf(codes.Unauthenticated, "no peer certificate in %T", p.AuthInfo)
+	}
+	if name := info.State.PeerCertificates[0].Subject.CommonName; name != "node-2" {
+		return nil, status.Errorf(codes.PermissionDenied, "unexpected peer %q", name)
+	}
+	return &grpc_health_v1.HealthCheckResponse{Status: grpc_health_v1.HealthCheckResponse_SERVING}, nil
+}
+
+func newTransport(t *testing.T, caCert, caKey []byte, name string) *memberlistquic.Transport {
+	t.Helper()
+	cert, key, err := tlsutil.GenerateNodeCertWithIPs(caCert, caKey, name, []net.IP{net.IPv4(127, 0, 0, 1)}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf, err := tlsutil.MutualTLSConfig(cert, key, caCert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr, err := memberlistquic.New(memberlistquic.Config{
+		BindAddr: "127.0.0.1",
+		TLS:      conf,
+		Logger:   log.New(io.Discard, "", 0),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = tr.Shutdown() })
+	return tr
+}
+
+func TestCredentials(t *testing.T) {
+	caCert, caKey, err := tlsutil.GenerateCA("test-org", 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := newTransport(t, caCert, caKey, "node-1")
+	client := newTransport(t, caCert, caKey, "node-2")
+
+	ln, err := server.Listen("grpc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := grpc.NewServer(grpc.Creds(NewCredentials()))
+	grpc_health_v1.RegisterHealthServer(srv, peerHealth{})
+	go func() { _ = srv.Serve(ln) }()
+	t.Cleanup(srv.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///"+transporttest.Addr(t, server),
+		grpc.WithContextDialer(client.StreamDialer("grpc")),
+		grpc.WithTransportCredentials(NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	var p peer.Peer
+	resp, err := grpc_health_v1.NewHealthClient(conn).Check(ctx, &grpc_health_v1.HealthCheckRequest{}, grpc.Peer(&p))
+	if err != nil {
+		t.Fatalf("call failed: %v", err)
+	}
+	if resp.Status != grpc_health_v1.HealthCheckResponse_SERVING {
+		t.Fatalf("unexpected status %v", resp.Status)
+	}
+
+	// The client sees the server's certificate the same way
+	info, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(info.State.PeerCertificates) == 0 || info.State.PeerCertificates[0].Subject.CommonName != "node-1" {
+		t.Fatalf("unexpected client peer info %+v", p.AuthInfo)
+	}
+}
+
+func TestCredentialsRejectPlainConn(t *testing.T) {
+	c, s := net.Pipe()
+	defer c.Close()
+	defer s.Close()
+	if _, _, err := NewCredentials().ServerHandshake(s); err == nil {
+		t.Fatal("expected a connection without TLS state to be rejected")
+	}
+}
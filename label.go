@@ -0,0 +1,123 @@
+package memberlistquic
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/quic-go/quic-go"
+)
+
+// maxClusterLabelLen keeps labelled ALPN protocol IDs well within the
+// 255 byte limit.
+const maxClusterLabelLen = 128
+
+// alertNoApplicationProtocol is the TLS alert sent when the client offers
+// no protocol the server supports, as when cluster labels differ.
+const alertNoApplicationProtocol = 120
+
+// labelALPN returns the ALPN protocol ID for a cluster label. Unlabelled
+// clusters use the base protocol ID, so they interoperate with transports
+// predating labels.
+func labelALPN(proto, label string) string {
+	if label == "" {
+		return proto
+	}
+	return proto + "+" + label
+}
+
+// alpnLabel returns the cluster label of a labelled ALPN protocol ID.
+func alpnLabel(proto, id string) (string, bool) {
+	if id == proto {
+		return "", true
+	}
+	label, ok := strings.CutPrefix(id, proto+"+")
+	return label, ok
+}
+
+func validateClusterLabel(label string) error {
+	if len(label) > maxClusterLabelLen {
+		return fmt.Errorf("cluster label longer than %d bytes", maxClusterLabelLen)
+	}
+	for _, c := range label {
+		if c <= ' ' || c > '~' {
+			return fmt.Errorf("cluster label %q must be printable ASCII without spaces", label)
+		}
+	}
+	return nil
+}
+
+// IsLabelMismatch reports whether err is the result of a peer refusing a
+// connection because it belongs to a cluster with a different label.
+func IsLabelMismatch(err error) bool {
+	var labelErr *labelMismatchError
+	return errors.As(err, &labelErr)
+}
+
+// labelMismatchError is returned when dialing a peer with a different
+// cluster label.
+type labelMismatchError struct {
+	addr  string
+	label string
+	err   error
+}
+
+func (e *labelMismatchError) Error() string {
+	return fmt.Sprintf("peer %s refused cluster label %q: peer belongs to a different cluster", e.addr, e.label)
+}
+
+func (e *labelMismatchError) Unwrap() error { return e.err }
+
+// unsupportedProtocolError is returned when a peer refuses the protocol
+// ID of a bootstrap or protocol connection.
+type unsupportedProtocolError struct {
+	addr  string
+	proto string
+	err   error
+}
+
+func (e *unsupportedProtocolError) Error() string {
+	return fmt.Sprintf("peer %s does not support protocol %q", e.addr, e.proto)
+}
+
+func (e *unsupportedProtocolError) Unwrap() error { return e.err }
+
+// dialError identifies a dial offering proto that the peer refused with a
+// no_application_protocol alert. For memberlist connections, which every
+// transport serves, this means the cluster labels differ; other protocols
+// may simply be unsupported by the peer.
+func (t *Transport) dialError(addr, proto string, err error) error {
+	var transportErr *quic.TransportError
+	if !errors.As(err, &transportErr) || !transportErr.Remote ||
+		transportErr.ErrorCode != quic.TransportErrorCode(0x100+alertNoApplicationProtocol) {
+		return err
+	}
+	if proto == t.alpn {
+		return &labelMismatchError{addr: addr, label: t.config.ClusterLabel, err: err}
+	}
+	return &unsupportedProtocolError{addr: addr, proto: proto, err: err}
+}
+
+// checkClientLabel logs an inbound handshake offering a different cluster
+// label than this transport's. The handshake then fails ALPN negotiation,
+// which the client reports as a label mismatch.
+func (t *Transport) checkClientLabel(hello *tls.ClientHelloInfo) {
+	for _, id := range hello.SupportedProtos {
+		if id == t.alpn || id == t.protoALPN || id == t.bootstrapALPN {
+			return
+		}
+	}
+	for _, id := range hello.SupportedProtos {
+		for _, proto := range []string{alpn, protocolALPN, bootstrapALPN} {
+			if label, ok := alpnLabel(proto, id); ok {
+				from := "unknown address"
+				if hello.Conn != nil {
+					from = hello.Conn.RemoteAddr().String()
+				}
+				t.logger.Printf("[WARN] memberlist-quic: refused connection from %s: cluster label %q does not match %q", from, label, t.config.ClusterLabel)
+				return
+			}
+		}
+	}
+}
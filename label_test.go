@@ -0,0 +1,83 @@
+package memberlistquic
+
+import (
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/memberlist"
+	"github.com/wjordan/memberlist-quic/tlsutil"
+)
+
+func TestClusterLabel(t *testing.T) {
+	caCert, caKey, err := tlsutil.GenerateCA("test-org", 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var logs syncBuffer
+	newTransport := func(name, label string) (*Transport, memberlist.Address) {
+		cert, key, err := tlsutil.GenerateNodeCertWithIPs(caCert, caKey, name, []net.IP{net.IPv4(127, 0, 0, 1)}, time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+		conf, err := tlsutil.MutualTLSConfig(cert, key, caCert)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tr, err := New(Config{
+			BindAddr:     "127.0.0.1",
+			TLS:          conf,
+			ClusterLabel: label,
+			Logger:       log.New(&logs, name+" ", 0),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { _ = tr.Shutdown() })
+		ip, port, err := tr.FinalAdvertiseAddr("", 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tr, memberlist.Address{Addr: net.JoinHostPort(ip.String(), strconv.Itoa(port)), Name: name}
+	}
+
+	prod1, prod1Addr := newTransport("prod-1", "prod")
+	prod2, _ := newTransport("prod-2", "prod")
+	staging, _ := newTransport("staging-1", "staging")
+	unlabelled, _ := newTransport("other-1", "")
+
+	if _, err := prod2.WriteToAddress([]byte("ping"), prod1Addr); err != nil {
+		t.Fatalf("same label: %v", err)
+	}
+	for _, tr := range []*Transport{staging, unlabelled} {
+		_, err := tr.WriteToAddress([]byte("ping"), prod1Addr)
+		if !IsLabelMismatch(err) {
+			t.Fatalf("expected label mismatch, got %v", err)
+		}
+		if !strings.Contains(err.Error(), "different cluster") {
+			t.Errorf("unclear error: %v", err)
+		}
+	}
+	if n := prod1.pool.Len(); n != 1 {
+		t.Fatalf("expected only the prod peer to be pooled, got %d connections", n)
+	}
+
+	out := logs.String()
+	for _, want := range []string{
+		`prod-1 [WARN] memberlist-quic: refused connection from 127.0.0.1:`,
+		`cluster label "staging" does not match "prod"`,
+		`cluster label "" does not match "prod"`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("missing %q in log:\n%s", want, out)
+		}
+	}
+
+	if _, err := New(Config{BindAddr: "127.0.0.1", TLS: prod1.tlsConfig.Load(), ClusterLabel: "has space"}); err == nil {
+		t.Fatal("expected error for invalid cluster label")
+	}
+}
@@ -0,0 +1,133 @@
+package memberlistquic
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/quic-go/quic-go"
+)
+
+// StreamListener is a net.Listener accepting streams that peers open for
+// a named protocol on their connections to the transport, as created by
+// Transport.Listen. Accepted connections, like those returned by
+// Transport.StreamDialer, have a ConnectionState method returning the TLS
+// state of the underlying QUIC connection, for servers such as gRPC that
+// authenticate peers by their certificate.
+type StreamListener struct {
+	t      *Transport
+	name   string
+	connCh chan net.Conn
+	doneCh chan struct{}
+	close  sync.Once
+}
+
+var _ net.Listener = (*StreamListener)(nil)
+
+// Listen returns a listener for streams opened for the protocol name, so
+// that application servers such as gRPC can serve other members over the
+// transport's authenticated connections:
+//
+//	ln, err := transport.Listen("grpc")
+//	go grpcServer.Serve(ln)
+//
+// Peers connect with StreamDialer using the same name; for gRPC, both
+// sides use the credentials from the grpcquic package. Streams are only
+// accepted on connections that passed the transport's TLS verification
+// and Authorizer.
+func (t *Transport) Listen(name string) (*StreamListener, error) {
+	if len(name) == 0 || len(name) > 255 {
+		return nil, fmt.Errorf("invalid protocol name %q", name)
+	}
+	l := &StreamListener{
+		t:      t,
+		name:   name,
+		connCh: make(chan net.Conn, t.config.StreamQueueSize),
+		doneCh: make(chan struct{}),
+	}
+
+	t.protocolsMu.Lock()
+	defer t.protocolsMu.Unlock()
+	if _, ok := t.protocols[name]; ok {
+		return nil, fmt.Errorf("protocol %q is already in use", name)
+	}
+	t.protocols[name] = l.serve
+	return l, nil
+}
+
+func (l *StreamListener) serve(conn *quic.Conn, stream *quic.Stream) {
+	select {
+	case l.connCh <- newStreamConn(conn, stream):
+	case <-l.doneCh:
+		stream.CancelRead(0)
+		stream.CancelWrite(0)
+	case <-l.t.shutdownCh:
+		stream.Close()
+	}
+}
+
+// Accept waits for and returns the next stream.
+func (l *StreamListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.connCh:
+		return conn, nil
+	case <-l.doneCh:
+		return nil, net.ErrClosed
+	case <-l.t.shutdownCh:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close stops accepting streams for the protocol and resets any not yet
+// accepted. Accepted streams are unaffected.
+func (l *StreamListener) Close() error {
+	l.close.Do(func() {
+		l.t.protocolsMu.Lock()
+		delete(l.t.protocols, l.name)
+		l.t.protocolsMu.Unlock()
+		close(l.doneCh)
+		for {
+			select {
+			case conn := <-l.connCh:
+				sc := conn.(*quicStreamConn)
+				sc.stream.CancelRead(0)
+				sc.stream.CancelWrite(0)
+			default:
+				return
+			}
+		}
+	})
+	return nil
+}
+
+// Addr returns the transport's local address.
+func (l *StreamListener) Addr() net.Addr {
+	return l.t.listener.Addr()
+}
+
+// StreamDialer returns a dial function opening a stream for the protocol
+// name to a StreamListener on the peer at addr ("host:port"), reusing
+// the pooled connection to it or dialing one. It has the signature
+// expected by grpc.WithContextDialer:
+//
+//	conn, err := grpc.NewClient("passthrough:///10.0.0.2:7946",
+//		grpc.WithContextDialer(transport.StreamDialer("grpc")), ...)
+//
+// The peer's certificate is verified against its IP address, as with
+// ConnPool.GetOrDial. ctx bounds dialing and opening the stream only.
+func (t *Transport) StreamDialer(name string) func(ctx context.Context, addr string) (net.Conn, error) {
+	return func(ctx context.Context, addr string) (net.Conn, error) {
+		conn, err := t.dialProtocol(ctx, addr, "")
+		if err != nil {
+			return nil, err
+		}
+		stream, err := openProtocolStream(ctx, conn, name)
+		if err != nil {
+			return nil, err
+		}
+		stream.SetDeadline(time.Time{})
+		return newStreamConn(conn, stream), nil
+	}
+}
@@ -0,0 +1,105 @@
+package memberlistquic
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/wjordan/memberlist-quic/tlsutil"
+)
+
+func TestStreamListener(t *testing.T) {
+	caCert, caKey, err := tlsutil.GenerateCA("test-org", 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server, _ := createTestTransport(t, caCert, caKey, "node-1")
+	client, _ := createTestTransport(t, caCert, caKey, "node-2")
+
+	ln, err := server.Listen("http/1.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := server.Listen("http/1.1"); err == nil {
+		t.Fatal("expected error listening on a protocol twice")
+	}
+	if _, err := server.Listen(renewProtocol); err == nil {
+		t.Fatal("expected error listening on a built-in protocol")
+	}
+
+	// Any net.Listener-based server works; the handler identifies the
+	// peer from the stream's TLS state
+	type peerKey struct{}
+	srv := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = io.WriteString(w, "hello "+r.Context().Value(peerKey{}).(string))
+		}),
+		ConnContext: func(ctx context.Context, c net.Conn) context.Context {
+			state := c.(interface{ ConnectionState() tls.ConnectionState }).ConnectionState()
+			return context.WithValue(ctx, peerKey{}, state.PeerCertificates[0].Subject.CommonName)
+		},
+	}
+	go func() { _ = srv.Serve(ln) }()
+	t.Cleanup(func() { _ = srv.Close() })
+
+	dial := client.StreamDialer("http/1.1")
+	httpClient := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			conn, err := dial(ctx, addr)
+			if err != nil {
+				return nil, err
+			}
+			if cn := conn.(interface{ ConnectionState() tls.ConnectionState }).ConnectionState().PeerCertificates[0].Subject.CommonName; cn != "node-1" {
+				t.Errorf("expected dialed peer node-1, got %s", cn)
+			}
+			return conn, nil
+		},
+	}}
+	t.Cleanup(httpClient.CloseIdleConnections)
+
+	_, port, err := server.FinalAdvertiseAddr("", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	url := "http://127.0.0.1:" + strconv.Itoa(port) + "/"
+	for range 3 {
+		resp, err := httpClient.Get(url)
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if string(body) != "hello node-2" {
+			t.Fatalf("unexpected response %q", body)
+		}
+	}
+	if n := client.protoPool.Len(); n != 1 {
+		t.Fatalf("expected streams to share the pooled connection, got %d connections", n)
+	}
+
+	// Once closed, the listener returns net.ErrClosed and new streams for
+	// its protocol are reset
+	ln.Close()
+	if _, err := ln.Accept(); !errors.Is(err, net.ErrClosed) {
+		t.Fatalf("expected net.ErrClosed, got %v", err)
+	}
+	httpClient.CloseIdleConnections()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, err := dial(ctx, "127.0.0.1:"+strconv.Itoa(port))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_, _ = conn.Write([]byte("GET / HTTP/1.1\r\n\r\n"))
+	if _, err := io.ReadAll(conn); err == nil {
+		t.Fatal("expected stream to be reset after closing the listener")
+	}
+}
@@ -0,0 +1,421 @@
+// Package netem provides an in-memory packet network for testing clusters
+// of QUIC transports in a single process. Conns created on a Network
+// implement net.PacketConn and can be passed to memberlistquic.Config as
+// PacketConn. Links between conns can be impaired with latency, jitter,
+// loss, duplication and reordering, and the network can be partitioned.
+//
+// Impairment decisions are drawn from a seeded random source so that a
+// scenario replays the same way given the same sequence of writes.
+package netem
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	defaultQueueSize = 1 << 20
+	ephemeralPortMin = 49152
+)
+
+// LinkConfig describes the impairments applied to packets sent over a link.
+// The zero value is a perfect link.
+type LinkConfig struct {
+	// Latency is the fixed one-way delay applied to each packet.
+	Latency time.Duration
+	// Jitter adds a uniformly distributed random delay in [0, Jitter).
+	Jitter time.Duration
+	// Loss is the probability in [0, 1] that a packet is dropped.
+	Loss float64
+	// Duplicate is the probability in [0, 1] that a packet is delivered twice.
+	Duplicate float64
+	// Reorder is the probability in [0, 1] that a packet is held back by
+	// ReorderDelay, letting later packets overtake it.
+	Reorder      float64
+	ReorderDelay time.Duration
+	// MTU drops packets larger than this many bytes. Zero means unlimited.
+	MTU int
+}
+
+type link struct {
+	from, to string
+}
+
+// Network is a virtual switch connecting in-memory packet conns.
+type Network struct {
+	mu          sync.Mutex
+	rng         *rand.Rand
+	conns       map[string]*PacketConn
+	links       map[link]LinkConfig
+	defaultLink LinkConfig
+	groups      map[string]int // addr → partition group, empty when healed
+	nextPort    int
+}
+
+// NewNetwork creates an empty network whose impairments are driven by the
+// given seed.
+func NewNetwork(seed uint64) *Network {
+	return &Network{
+		rng:      rand.New(rand.NewPCG(seed, seed)),
+		conns:    make(map[string]*PacketConn),
+		links:    make(map[link]LinkConfig),
+		nextPort: ephemeralPortMin,
+	}
+}
+
+// ListenPacket creates a conn bound to addr, which must be an IP:port
+// pair. A zero port picks an unused one.
+func (n *Network) ListenPacket(addr string) (*PacketConn, error) {
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if udpAddr.IP == nil {
+		return nil, fmt.Errorf("netem: address %q has no IP", addr)
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if udpAddr.Port == 0 {
+		for {
+			udpAddr.Port = n.nextPort
+			n.nextPort++
+			if _, ok := n.conns[udpAddr.String()]; !ok {
+				break
+			}
+		}
+	}
+	key := udpAddr.String()
+	if _, ok := n.conns[key]; ok {
+		return nil, fmt.Errorf("netem: address %s already in use", key)
+	}
+
+	c := newPacketConn(n, udpAddr)
+	n.conns[key] = c
+	return c, nil
+}
+
+// SetDefaultLink sets the impairments for links without a specific
+// configuration.
+func (n *Network) SetDefaultLink(cfg LinkConfig) {
+	n.mu.Lock()
+	n.defaultLink = cfg
+	n.mu.Unlock()
+}
+
+// SetLink sets the impairments for packets sent from one address to
+// another. The link is directional; configure both directions for a
+// symmetric impairment.
+func (n *Network) SetLink(from, to string, cfg LinkConfig) {
+	n.mu.Lock()
+	n.links[link{from, to}] = cfg
+	n.mu.Unlock()
+}
+
+// ClearLinks removes all per-link configurations.
+func (n *Network) ClearLinks() {
+	n.mu.Lock()
+	n.links = make(map[link]LinkConfig)
+	n.mu.Unlock()
+}
+
+// Partition splits the network into the given groups of addresses.
+// Packets only flow between addresses in the same group; addresses not
+// listed in any group are isolated from everyone.
+func (n *Network) Partition(groups ...[]string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.groups = make(map[string]int)
+	for i, group := range groups {
+		for _, addr := range group {
+			n.groups[addr] = i
+		}
+	}
+}
+
+// Heal removes any partition.
+func (n *Network) Heal() {
+	n.mu.Lock()
+	n.groups = nil
+	n.mu.Unlock()
+}
+
+func (n *Network) reachable(from, to string) bool {
+	if n.groups == nil {
+		return true
+	}
+	gFrom, ok := n.groups[from]
+	if !ok {
+		return false
+	}
+	gTo, ok := n.groups[to]
+	return ok && gFrom == gTo
+}
+
+// send applies the link impairments and schedules delivery of b.
+func (n *Network) send(from *net.UDPAddr, to net.Addr, b []byte) error {
+	toKey := to.String()
+
+	n.mu.Lock()
+	dst, ok := n.conns[toKey]
+	if !ok || !n.reachable(from.String(), toKey) {
+		// Unreachable destinations silently drop, as UDP does
+		n.mu.Unlock()
+		return nil
+	}
+	cfg, ok := n.links[link{from.String(), toKey}]
+	if !ok {
+		cfg = n.defaultLink
+	}
+	if cfg.MTU > 0 && len(b) > cfg.MTU {
+		n.mu.Unlock()
+		return nil
+	}
+	if cfg.Loss > 0 && n.rng.Float64() < cfg.Loss {
+		n.mu.Unlock()
+		return nil
+	}
+	copies := 1
+	if cfg.Duplicate > 0 && n.rng.Float64() < cfg.Duplicate {
+		copies = 2
+	}
+	delays := make([]time.Duration, copies)
+	for i := range delays {
+		delay := cfg.Latency
+		if cfg.Jitter > 0 {
+			delay += time.Duration(n.rng.Int64N(int64(cfg.Jitter)))
+		}
+		if cfg.Reorder > 0 && n.rng.Float64() < cfg.Reorder {
+			delay += cfg.ReorderDelay
+		}
+		delays[i] = delay
+	}
+	n.mu.Unlock()
+
+	for _, delay := range delays {
+		p := packet{buf: append([]byte(nil), b...), from: from}
+		if delay <= 0 {
+			dst.enqueue(p)
+			continue
+		}
+		time.AfterFunc(delay, func() { dst.enqueue(p) })
+	}
+	return nil
+}
+
+func (n *Network) remove(c *PacketConn) {
+	n.mu.Lock()
+	if n.conns[c.addr.String()] == c {
+		delete(n.conns, c.addr.String())
+	}
+	n.mu.Unlock()
+}
+
+type packet struct {
+	buf  []byte
+	from net.Addr
+}
+
+// PacketConn is an in-memory net.PacketConn attached to a Network.
+type PacketConn struct {
+	network *Network
+	addr    *net.UDPAddr
+
+	mu        sync.Mutex
+	queue     []packet
+	queued    int // bytes currently queued
+	queueSize int
+	notify    chan struct{}
+	closed    bool
+	closeCh   chan struct{}
+
+	readDeadline  deadline
+	writeDeadline deadline
+}
+
+var _ net.PacketConn = (*PacketConn)(nil)
+
+func newPacketConn(n *Network, addr *net.UDPAddr) *PacketConn {
+	return &PacketConn{
+		network:       n,
+		addr:          addr,
+		queueSize:     defaultQueueSize,
+		notify:        make(chan struct{}, 1),
+		closeCh:       make(chan struct{}),
+		readDeadline:  makeDeadline(),
+		writeDeadline: makeDeadline(),
+	}
+}
+
+func (c *PacketConn) enqueue(p packet) {
+	c.mu.Lock()
+	if c.closed || c.queued+len(p.buf) > c.queueSize {
+		// Receive buffer overflow drops the packet
+		c.mu.Unlock()
+		return
+	}
+	c.queue = append(c.queue, p)
+	c.queued += len(p.buf)
+	c.mu.Unlock()
+
+	select {
+	case c.notify <- struct{}{}:
+	default:
+	}
+}
+
+// ReadFrom reads the next packet delivered to the conn.
+func (c *PacketConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	for {
+		c.mu.Lock()
+		if c.closed {
+			c.mu.Unlock()
+			return 0, nil, c.opError("read", net.ErrClosed)
+		}
+		if len(c.queue) > 0 {
+			p := c.queue[0]
+			c.queue[0] = packet{}
+			c.queue = c.queue[1:]
+			c.queued -= len(p.buf)
+			c.mu.Unlock()
+			return copy(b, p.buf), p.from, nil
+		}
+		c.mu.Unlock()
+
+		select {
+		case <-c.notify:
+		case <-c.readDeadline.wait():
+			return 0, nil, c.opError("read", os.ErrDeadlineExceeded)
+		case <-c.closeCh:
+		}
+	}
+}
+
+// WriteTo sends b to addr through the network.
+func (c *PacketConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	select {
+	case <-c.closeCh:
+		return 0, c.opError("write", net.ErrClosed)
+	case <-c.writeDeadline.wait():
+		return 0, c.opError("write", os.ErrDeadlineExceeded)
+	default:
+	}
+	if err := c.network.send(c.addr, addr, b); err != nil {
+		return 0, c.opError("write", err)
+	}
+	return len(b), nil
+}
+
+// Close detaches the conn from the network.
+func (c *PacketConn) Close() error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return c.opError("close", net.ErrClosed)
+	}
+	c.closed = true
+	c.queue = nil
+	c.queued = 0
+	c.mu.Unlock()
+
+	close(c.closeCh)
+	c.network.remove(c)
+	return nil
+}
+
+// LocalAddr returns the conn's address on the network.
+func (c *PacketConn) LocalAddr() net.Addr { return c.addr }
+
+func (c *PacketConn) SetDeadline(t time.Time) error {
+	c.readDeadline.set(t)
+	c.writeDeadline.set(t)
+	return nil
+}
+
+func (c *PacketConn) SetReadDeadline(t time.Time) error {
+	c.readDeadline.set(t)
+	return nil
+}
+
+func (c *PacketConn) SetWriteDeadline(t time.Time) error {
+	c.writeDeadline.set(t)
+	return nil
+}
+
+// SetReadBuffer sets the number of bytes that may be queued for reading
+// before further packets are dropped.
+func (c *PacketConn) SetReadBuffer(bytes int) error {
+	c.mu.Lock()
+	c.queueSize = bytes
+	c.mu.Unlock()
+	return nil
+}
+
+// SetWriteBuffer is a no-op; writes are never blocked by buffer space.
+func (c *PacketConn) SetWriteBuffer(int) error { return nil }
+
+func (c *PacketConn) opError(op string, err error) error {
+	return &net.OpError{Op: op, Net: "netem", Addr: c.addr, Err: err}
+}
+
+// deadline is a resettable deadline signalled by closing a channel.
+type deadline struct {
+	mu     *sync.Mutex
+	timer  *time.Timer
+	cancel chan struct{}
+}
+
+func makeDeadline() deadline {
+	return deadline{mu: new(sync.Mutex), cancel: make(chan struct{})}
+}
+
+func (d *deadline) set(t time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.timer != nil && !d.timer.Stop() {
+		<-d.cancel // wait for the timer callback to finish
+	}
+	d.timer = nil
+
+	closed := isClosed(d.cancel)
+	if t.IsZero() {
+		if closed {
+			d.cancel = make(chan struct{})
+		}
+		return
+	}
+
+	if dur := time.Until(t); dur > 0 {
+		if closed {
+			d.cancel = make(chan struct{})
+		}
+		cancel := d.cancel
+		d.timer = time.AfterFunc(dur, func() { close(cancel) })
+		return
+	}
+
+	if !closed {
+		close(d.cancel)
+	}
+}
+
+func (d *deadline) wait() <-chan struct{} {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.cancel
+}
+
+func isClosed(c chan struct{}) bool {
+	select {
+	case <-c:
+		return true
+	default:
+		return false
+	}
+}
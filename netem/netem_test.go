@@ -0,0 +1,156 @@
+package netem
+
+import (
+	"errors"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+func listen(t *testing.T, n *Network, addr string) *PacketConn {
+	t.Helper()
+	c, err := n.ListenPacket(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = c.Close() })
+	return c
+}
+
+func readWithin(c *PacketConn, d time.Duration) ([]byte, net.Addr, error) {
+	_ = c.SetReadDeadline(time.Now().Add(d))
+	buf := make([]byte, 1500)
+	n, from, err := c.ReadFrom(buf)
+	return buf[:n], from, err
+}
+
+func TestDelivery(t *testing.T) {
+	n := NewNetwork(1)
+	a := listen(t, n, "10.0.0.1:0")
+	b := listen(t, n, "10.0.0.2:7946")
+
+	if _, err := a.WriteTo([]byte("hello"), b.LocalAddr()); err != nil {
+		t.Fatal(err)
+	}
+	msg, from, err := readWithin(b, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(msg) != "hello" {
+		t.Fatalf("expected hello, got %q", msg)
+	}
+	if from.String() != a.LocalAddr().String() {
+		t.Fatalf("expected from %s, got %s", a.LocalAddr(), from)
+	}
+
+	if _, err := n.ListenPacket("10.0.0.2:7946"); err == nil {
+		t.Fatal("expected error binding an address in use")
+	}
+}
+
+func TestReadDeadline(t *testing.T) {
+	n := NewNetwork(1)
+	a := listen(t, n, "10.0.0.1:0")
+
+	_, _, err := readWithin(a, 10*time.Millisecond)
+	if !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+	var nerr net.Error
+	if !errors.As(err, &nerr) || !nerr.Timeout() {
+		t.Fatalf("expected timeout net.Error, got %v", err)
+	}
+
+	_ = a.Close()
+	if _, _, err := a.ReadFrom(make([]byte, 1)); !errors.Is(err, net.ErrClosed) {
+		t.Fatalf("expected net.ErrClosed after close, got %v", err)
+	}
+}
+
+func TestLossAndLatency(t *testing.T) {
+	n := NewNetwork(1)
+	a := listen(t, n, "10.0.0.1:0")
+	b := listen(t, n, "10.0.0.2:0")
+
+	n.SetLink(a.LocalAddr().String(), b.LocalAddr().String(), LinkConfig{Loss: 1})
+	_, _ = a.WriteTo([]byte("lost"), b.LocalAddr())
+	if _, _, err := readWithin(b, 20*time.Millisecond); err == nil {
+		t.Fatal("expected packet to be dropped")
+	}
+
+	n.SetLink(a.LocalAddr().String(), b.LocalAddr().String(), LinkConfig{Latency: 50 * time.Millisecond})
+	start := time.Now()
+	_, _ = a.WriteTo([]byte("slow"), b.LocalAddr())
+	if _, _, err := readWithin(b, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Fatalf("expected at least 50ms latency, got %s", elapsed)
+	}
+}
+
+func TestDuplicate(t *testing.T) {
+	n := NewNetwork(1)
+	a := listen(t, n, "10.0.0.1:0")
+	b := listen(t, n, "10.0.0.2:0")
+
+	n.SetDefaultLink(LinkConfig{Duplicate: 1})
+	_, _ = a.WriteTo([]byte("twice"), b.LocalAddr())
+	for i := 0; i < 2; i++ {
+		if _, _, err := readWithin(b, time.Second); err != nil {
+			t.Fatalf("copy %d: %v", i, err)
+		}
+	}
+}
+
+func TestPartition(t *testing.T) {
+	n := NewNetwork(1)
+	a := listen(t, n, "10.0.0.1:0")
+	b := listen(t, n, "10.0.0.2:0")
+	c := listen(t, n, "10.0.0.3:0")
+
+	n.Partition(
+		[]string{a.LocalAddr().String(), b.LocalAddr().String()},
+		[]string{c.LocalAddr().String()},
+	)
+
+	_, _ = a.WriteTo([]byte("same side"), b.LocalAddr())
+	if _, _, err := readWithin(b, time.Second); err != nil {
+		t.Fatalf("expected delivery within partition: %v", err)
+	}
+	_, _ = a.WriteTo([]byte("other side"), c.LocalAddr())
+	if _, _, err := readWithin(c, 20*time.Millisecond); err == nil {
+		t.Fatal("expected packet across partition to be dropped")
+	}
+
+	n.Heal()
+	_, _ = a.WriteTo([]byte("healed"), c.LocalAddr())
+	if _, _, err := readWithin(c, time.Second); err != nil {
+		t.Fatalf("expected delivery after heal: %v", err)
+	}
+}
+
+func TestSeededLossIsDeterministic(t *testing.T) {
+	pattern := func() []bool {
+		n := NewNetwork(42)
+		a := listen(t, n, "10.0.0.1:0")
+		b := listen(t, n, "10.0.0.2:0")
+		n.SetDefaultLink(LinkConfig{Loss: 0.5})
+
+		var got []bool
+		for i := 0; i < 32; i++ {
+			_, _ = a.WriteTo([]byte{byte(i)}, b.LocalAddr())
+			_, _, err := readWithin(b, 5*time.Millisecond)
+			got = append(got, err == nil)
+		}
+		return got
+	}
+
+	first, second := pattern(), pattern()
+	for i := range first {
+		if first[i] != second[i] {
+			t.Fatalf("loss pattern differs at packet %d", i)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package memberlistquic
+
+import (
+	"crypto/tls"
+	"net"
+
+	"github.com/quic-go/quic-go"
+)
+
+// PeerAddr is the address of the peer a packet or stream came from, as
+// found in memberlist.Packet.From and the RemoteAddr of streams from
+// StreamCh. It behaves as the peer's UDP address, so memberlist is
+// unaffected, and also gives delegates the identity the peer
+// authenticated with:
+//
+//	if peer, ok := packet.From.(*memberlistquic.PeerAddr); ok {
+//		log.Printf("packet from node %s", peer.NodeID())
+//	}
+type PeerAddr struct {
+	net.Addr
+	conn *quic.Conn
+}
+
+func newPeerAddr(conn *quic.Conn) *PeerAddr {
+	return &PeerAddr{Addr: conn.RemoteAddr(), conn: conn}
+}
+
+// Conn returns the QUIC connection to the peer.
+func (a *PeerAddr) Conn() *quic.Conn {
+	return a.conn
+}
+
+// ConnectionState returns the TLS state of the connection to the peer.
+func (a *PeerAddr) ConnectionState() tls.ConnectionState {
+	return a.conn.ConnectionState().TLS
+}
+
+// Certificate describes the certificate the peer authenticated with,
+// leaving Leaf nil if it presented none.
+func (a *PeerAddr) Certificate() CertificateInfo {
+	return peerInfo(a.conn)
+}
+
+// NodeID returns the node ID from the peer's certificate, or "" if it has
+// none.
+func (a *PeerAddr) NodeID() string {
+	return a.Certificate().NodeID
+}
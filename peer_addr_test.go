@@ -0,0 +1,90 @@
+package memberlistquic
+
+import (
+	"crypto/tls"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/memberlist"
+	"github.com/quic-go/quic-go"
+	"github.com/wjordan/memberlist-quic/tlsutil"
+)
+
+func TestPeerAddr(t *testing.T) {
+	caCert, caKey, err := tlsutil.GenerateCA("test-org", 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tr1, _ := createTestTransport(t, caCert, caKey, "node-1")
+	tr2, _ := createTestTransport(t, caCert, caKey, "node-2")
+	addrOf := func(tr *Transport) string {
+		ip, port, err := tr.FinalAdvertiseAddr("", 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return net.JoinHostPort(ip.String(), strconv.Itoa(port))
+	}
+	addr1, addr2 := addrOf(tr1), addrOf(tr2)
+
+	checkPeer := func(what string, from net.Addr) {
+		t.Helper()
+		peer, ok := from.(*PeerAddr)
+		if !ok {
+			t.Fatalf("%s: expected *PeerAddr, got %T", what, from)
+		}
+		if peer.String() != addr2 || peer.Network() != "udp" {
+			t.Errorf("%s: expected udp address %s, got %s %s", what, addr2, peer.Network(), peer)
+		}
+		if peer.NodeID() != "node-2" {
+			t.Errorf("%s: expected node ID node-2, got %q", what, peer.NodeID())
+		}
+		if info := peer.Certificate(); info.Leaf == nil || info.Leaf.Subject.CommonName != "node-2" {
+			t.Errorf("%s: missing peer certificate", what)
+		}
+		if len(peer.ConnectionState().VerifiedChains) == 0 || peer.Conn() == nil {
+			t.Errorf("%s: missing connection state", what)
+		}
+	}
+
+	if _, err := tr2.WriteTo([]byte("ping"), addr1); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case packet := <-tr1.PacketCh():
+		checkPeer("packet", packet.From)
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for packet")
+	}
+
+	out, err := tr2.DialAddressTimeout(memberlist.Address{Addr: addr1}, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+	if _, err := out.Write([]byte{1}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case in := <-tr1.StreamCh():
+		defer in.Close()
+		checkPeer("stream", in.RemoteAddr())
+		sc, ok := in.(interface {
+			QUICConn() *quic.Conn
+			ConnectionState() tls.ConnectionState
+		})
+		if !ok {
+			t.Fatalf("stream %T does not expose its connection", in)
+		}
+		if sc.QUICConn() != in.RemoteAddr().(*PeerAddr).Conn() {
+			t.Error("stream and address report different connections")
+		}
+		if cs := sc.ConnectionState(); len(cs.PeerCertificates) == 0 {
+			t.Error("stream is missing peer certificates")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for stream")
+	}
+}
@@ -3,18 +3,32 @@ package memberlistquic
 import (
 	"context"
 	"crypto/tls"
+	"crypto/x509"
+	"fmt"
 	"log"
 	"net"
+	"path"
 	"sync"
 	"time"
 
 	"github.com/quic-go/quic-go"
+	"github.com/wjordan/memberlist-quic/tlsutil"
 )
 
 type poolEntry struct {
 	mu        sync.Mutex
 	conn      *quic.Conn
 	createdAt time.Time
+
+	// others holds further connections with the same peer, such as the
+	// inbound one when both sides dial each other at once. They are
+	// tracked so they can be closed, and replace conn once it closes.
+	others []trackedConn
+}
+
+type trackedConn struct {
+	conn      *quic.Conn
+	createdAt time.Time
 }
 
 func (e *poolEntry) setConn(conn *quic.Conn) {
@@ -22,14 +36,31 @@ func (e *poolEntry) setConn(conn *quic.Conn) {
 	e.createdAt = time.Now()
 }
 
+// conns returns every connection tracked by the entry. Caller must hold
+// e.mu.
+func (e *poolEntry) conns() []*quic.Conn {
+	var conns []*quic.Conn
+	if e.conn != nil {
+		conns = append(conns, e.conn)
+	}
+	for _, other := range e.others {
+		conns = append(conns, other.conn)
+	}
+	return conns
+}
+
 // ConnPool manages QUIC connections to peers.
 type ConnPool struct {
 	transport  *quic.Transport
-	tlsConfig  *tls.Config
+	tlsConfig  func() *tls.Config
 	quicConfig *quic.Config
 	entries    sync.Map // addr string → *poolEntry
 	logger     *log.Logger
 
+	// authorize is called on each newly dialed connection before it is
+	// used, and closes the connection if it returns an error.
+	authorize func(conn *quic.Conn) error
+
 	// onNewConn is called when a new outbound connection is dialed.
 	// The Transport uses this to start receive goroutines.
 	onNewConn func(conn *quic.Conn)
@@ -41,7 +72,7 @@ type ConnPool struct {
 	wg         sync.WaitGroup
 }
 
-func newConnPool(transport *quic.Transport, tlsConfig *tls.Config, quicConfig *quic.Config, logger *log.Logger, maxAge, sweepInterval time.Duration, onNewConn func(*quic.Conn)) *ConnPool {
+func newConnPool(transport *quic.Transport, tlsConfig func() *tls.Config, quicConfig *quic.Config, logger *log.Logger, maxAge, sweepInterval time.Duration, authorize func(*quic.Conn) error, onNewConn func(*quic.Conn)) *ConnPool {
 	p := &ConnPool{
 		transport:     transport,
 		tlsConfig:     tlsConfig,
@@ -49,6 +80,7 @@ func newConnPool(transport *quic.Transport, tlsConfig *tls.Config, quicConfig *q
 		logger:        logger,
 		maxAge:        maxAge,
 		sweepInterval: sweepInterval,
+		authorize:     authorize,
 		onNewConn:     onNewConn,
 		shutdownCh:    make(chan struct{}),
 	}
@@ -62,11 +94,21 @@ func newConnPool(transport *quic.Transport, tlsConfig *tls.Config, quicConfig *q
 }
 
 // getAliveConn returns the connection from an entry if it's alive, or nil.
+// If it has closed, another live connection to the peer takes its place.
 // Caller must hold entry.mu.
 func getAliveConn(entry *poolEntry) *quic.Conn {
 	if entry.conn != nil && entry.conn.Context().Err() == nil {
 		return entry.conn
 	}
+	entry.conn = nil
+	for len(entry.others) > 0 {
+		other := entry.others[0]
+		entry.others = entry.others[1:]
+		if other.conn.Context().Err() == nil {
+			entry.conn, entry.createdAt = other.conn, other.createdAt
+			return entry.conn
+		}
+	}
 	return nil
 }
 
@@ -86,10 +128,25 @@ func (p *ConnPool) GetConnection(addr string) *quic.Conn {
 	return conn
 }
 
-// GetOrDial returns an existing connection or dials a new one.
+// GetOrDial returns an existing connection or dials a new one. A new
+// connection verifies the peer's certificate against its IP address.
 func (p *ConnPool) GetOrDial(ctx context.Context, addr string) (*quic.Conn, error) {
+	return p.GetOrDialName(ctx, addr, "")
+}
+
+// GetOrDialName is like GetOrDial, but verifies that the peer's
+// certificate is valid for serverName rather than for its IP address.
+// An existing connection to addr is only returned if its peer certificate
+// is also valid for serverName, so a connection to a node that has taken
+// over another node's address is not mistaken for the old node. If the
+// TLS config skips the standard name check, as for pinned keys or SPIFFE
+// identities, the peer's node name must match serverName instead.
+func (p *ConnPool) GetOrDialName(ctx context.Context, addr, serverName string) (*quic.Conn, error) {
 	// Fast path: existing live connection
 	if conn := p.GetConnection(addr); conn != nil {
+		if err := p.verifyPeerName(conn, serverName); err != nil {
+			return nil, err
+		}
 		return conn, nil
 	}
 
@@ -104,6 +161,9 @@ func (p *ConnPool) GetOrDial(ctx context.Context, addr string) (*quic.Conn, erro
 
 	// Double-check under lock
 	if conn := getAliveConn(entry); conn != nil {
+		if err := p.verifyPeerName(conn, serverName); err != nil {
+			return nil, err
+		}
 		return conn, nil
 	}
 
@@ -113,13 +173,25 @@ func (p *ConnPool) GetOrDial(ctx context.Context, addr string) (*quic.Conn, erro
 		return nil, err
 	}
 
-	tlsConf := p.tlsConfig.Clone()
+	tlsConf := p.tlsConfig().Clone()
 	tlsConf.ServerName = udpAddr.IP.String()
+	if serverName != "" {
+		tlsConf.ServerName = serverName
+	}
 
 	conn, err := p.transport.Dial(ctx, udpAddr, tlsConf, p.quicConfig)
 	if err != nil {
 		return nil, err
 	}
+	if err := p.verifyPeerName(conn, serverName); err != nil {
+		_ = conn.CloseWithError(0, "unexpected peer name")
+		return nil, err
+	}
+	if p.authorize != nil {
+		if err := p.authorize(conn); err != nil {
+			return nil, err
+		}
+	}
 
 	entry.setConn(conn)
 
@@ -130,6 +202,47 @@ func (p *ConnPool) GetOrDial(ctx context.Context, addr string) (*quic.Conn, erro
 	return conn, nil
 }
 
+// verifyPeerName checks that conn's peer certificate is valid for
+// serverName, if one is given. Connections accepted from the peer were
+// never checked against a name, connections dialed for one name may be
+// reused for another, and handshakes with InsecureSkipVerify set do not
+// check the name at all.
+func (p *ConnPool) verifyPeerName(conn *quic.Conn, serverName string) error {
+	if serverName == "" {
+		return nil
+	}
+	certs := conn.ConnectionState().TLS.PeerCertificates
+	if len(certs) == 0 {
+		return fmt.Errorf("connection to %s has no peer certificate", conn.RemoteAddr())
+	}
+	if p.tlsConfig().InsecureSkipVerify {
+		return verifyNodeName(certs[0], serverName)
+	}
+	return certs[0].VerifyHostname(serverName)
+}
+
+// verifyNodeName checks that cert identifies the node name, for configs
+// that verify certificates themselves rather than by host name: the last
+// path segment or whole ID of a SPIFFE ID (see tlsutil.SPIFFENodeName),
+// or otherwise the node ID that tlsutil.KnownPeers pins to the key.
+func verifyNodeName(cert *x509.Certificate, name string) error {
+	if id, err := tlsutil.SPIFFEID(cert); err == nil {
+		node, err := tlsutil.SPIFFENodeName(id, path.Dir(id.Path))
+		if (err == nil && node == name) || id.String() == name {
+			return nil
+		}
+		return fmt.Errorf("peer SPIFFE ID %q is not node %q", id, name)
+	}
+	node, err := tlsutil.NodeIDFromCert(cert)
+	if err != nil {
+		return err
+	}
+	if node != name {
+		return fmt.Errorf("peer is node %q, not %q", node, name)
+	}
+	return nil
+}
+
 // CloseConnection closes and removes the connection to addr.
 func (p *ConnPool) CloseConnection(addr string) {
 	val, ok := p.entries.LoadAndDelete(addr)
@@ -138,8 +251,8 @@ func (p *ConnPool) CloseConnection(addr string) {
 	}
 	entry := val.(*poolEntry)
 	entry.mu.Lock()
-	if entry.conn != nil {
-		_ = entry.conn.CloseWithError(0, "connection closed")
+	for _, conn := range entry.conns() {
+		_ = conn.CloseWithError(0, "connection closed")
 	}
 	entry.mu.Unlock()
 }
@@ -158,6 +271,23 @@ func (p *ConnPool) Range(fn func(addr string, conn *quic.Conn) bool) {
 	})
 }
 
+// rangeAll calls fn for every live connection, including further
+// connections to peers that already have one.
+func (p *ConnPool) rangeAll(fn func(addr string, conn *quic.Conn)) {
+	p.entries.Range(func(key, value any) bool {
+		entry := value.(*poolEntry)
+		entry.mu.Lock()
+		conns := entry.conns()
+		entry.mu.Unlock()
+		for _, conn := range conns {
+			if conn.Context().Err() == nil {
+				fn(key.(string), conn)
+			}
+		}
+		return true
+	})
+}
+
 // Len returns the number of active connections.
 func (p *ConnPool) Len() int {
 	count := 0
@@ -174,6 +304,8 @@ func (p *ConnPool) Len() int {
 }
 
 // AddInbound registers an inbound (or externally established) connection in the pool.
+// If the peer already has a live connection, that one stays in use and conn
+// is tracked alongside it.
 func (p *ConnPool) AddInbound(conn *quic.Conn) {
 	addr := conn.RemoteAddr().String()
 	entry := &poolEntry{}
@@ -188,6 +320,14 @@ func (p *ConnPool) AddInbound(conn *quic.Conn) {
 	existing.mu.Lock()
 	if getAliveConn(existing) == nil {
 		existing.setConn(conn)
+	} else {
+		others := existing.others[:0]
+		for _, other := range existing.others {
+			if other.conn.Context().Err() == nil {
+				others = append(others, other)
+			}
+		}
+		existing.others = append(others, trackedConn{conn: conn, createdAt: time.Now()})
 	}
 	existing.mu.Unlock()
 }
@@ -197,18 +337,31 @@ func (p *ConnPool) sweep() {
 	p.entries.Range(func(key, value any) bool {
 		entry := value.(*poolEntry)
 		entry.mu.Lock()
-		if entry.conn == nil || entry.conn.Context().Err() != nil {
+		if p.maxAge > 0 {
+			others := entry.others[:0]
+			for _, other := range entry.others {
+				if now.Sub(other.createdAt) > p.maxAge {
+					_ = other.conn.CloseWithError(0, "max connection age exceeded")
+				} else {
+					others = append(others, other)
+				}
+			}
+			entry.others = others
+		}
+		if getAliveConn(entry) == nil {
 			entry.mu.Unlock()
 			p.entries.Delete(key)
 			return true
 		}
 		if p.maxAge > 0 && now.Sub(entry.createdAt) > p.maxAge {
 			_ = entry.conn.CloseWithError(0, "max connection age exceeded")
-			entry.mu.Unlock()
-			p.entries.Delete(key)
-			return true
+			entry.conn = nil
 		}
+		alive := getAliveConn(entry) != nil
 		entry.mu.Unlock()
+		if !alive {
+			p.entries.Delete(key)
+		}
 		return true
 	})
 }
@@ -228,17 +381,47 @@ func (p *ConnPool) sweepLoop() {
 	}
 }
 
-func (p *ConnPool) close() {
-	close(p.shutdownCh)
+// closeAll closes and removes every pooled connection.
+func (p *ConnPool) closeAll(reason string) {
 	p.entries.Range(func(key, value any) bool {
 		entry := value.(*poolEntry)
 		entry.mu.Lock()
-		if entry.conn != nil {
-			_ = entry.conn.CloseWithError(0, "transport shutdown")
+		for _, conn := range entry.conns() {
+			_ = conn.CloseWithError(0, reason)
 		}
 		entry.mu.Unlock()
 		p.entries.Delete(key)
 		return true
 	})
+}
+
+// closeIf closes and removes the live connections matching fn, returning
+// the number closed.
+func (p *ConnPool) closeIf(fn func(conn *quic.Conn) bool, reason string) int {
+	closed := 0
+	p.entries.Range(func(key, value any) bool {
+		entry := value.(*poolEntry)
+		entry.mu.Lock()
+		matched := false
+		for _, conn := range entry.conns() {
+			if conn.Context().Err() == nil && fn(conn) {
+				_ = conn.CloseWithError(0, reason)
+				matched = true
+				closed++
+			}
+		}
+		alive := getAliveConn(entry) != nil
+		entry.mu.Unlock()
+		if matched && !alive {
+			p.entries.CompareAndDelete(key, entry)
+		}
+		return true
+	})
+	return closed
+}
+
+func (p *ConnPool) close() {
+	close(p.shutdownCh)
+	p.closeAll("transport shutdown")
 	p.wg.Wait()
 }
@@ -0,0 +1,154 @@
+package memberlistquic
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/quic-go/quic-go"
+)
+
+const (
+	// protocolALPN is the ALPN protocol ID of connections carrying the
+	// transport's own stream protocols, kept apart from memberlist's
+	// connections so that memberlist streams are never inspected.
+	protocolALPN = "memberlist-quic-proto/1"
+
+	// protocolHeaderTimeout bounds how long an inbound protocol stream
+	// may take to send its protocol name before it is dispatched.
+	protocolHeaderTimeout = 10 * time.Second
+
+	// Responses to protocol requests start with a status byte, followed
+	// by the result or an error message.
+	statusOK    byte = 0
+	statusError byte = 1
+)
+
+// protocolHandler serves a stream opened for a named protocol. It owns the
+// stream and must close it.
+type protocolHandler func(conn *quic.Conn, stream *quic.Stream)
+
+// openProtocolStream opens a stream to conn, a connection from protoPool,
+// for the named protocol.
+func openProtocolStream(ctx context.Context, conn *quic.Conn, name string) (*quic.Stream, error) {
+	if len(name) == 0 || len(name) > 255 {
+		return nil, fmt.Errorf("invalid protocol name %q", name)
+	}
+	stream, err := conn.OpenStreamSync(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		stream.SetDeadline(deadline)
+	}
+	hdr := append([]byte{byte(len(name))}, name...)
+	if _, err := stream.Write(hdr); err != nil {
+		stream.CancelRead(0)
+		stream.CancelWrite(0)
+		return nil, err
+	}
+	return stream, nil
+}
+
+// protocol returns the handler registered for name, or nil.
+func (t *Transport) protocol(name string) protocolHandler {
+	t.protocolsMu.RLock()
+	defer t.protocolsMu.RUnlock()
+	return t.protocols[name]
+}
+
+// dialProtocol returns a protocol connection to the peer at addr,
+// verifying it against serverName as for ConnPool.GetOrDialName.
+func (t *Transport) dialProtocol(ctx context.Context, addr, serverName string) (*quic.Conn, error) {
+	conn, err := t.protoPool.GetOrDialName(ctx, addr, serverName)
+	if err != nil {
+		return nil, t.dialError(addr, t.protoALPN, err)
+	}
+	return conn, nil
+}
+
+// startProtocolHandlers starts accepting protocol streams on a newly
+// dialed or accepted protocol connection.
+func (t *Transport) startProtocolHandlers(conn *quic.Conn) {
+	t.wg.Add(1)
+	go t.acceptProtocolStreams(conn)
+}
+
+func (t *Transport) acceptProtocolStreams(conn *quic.Conn) {
+	defer t.wg.Done()
+	for {
+		stream, err := conn.AcceptStream(context.Background())
+		if err != nil {
+			return
+		}
+		select {
+		case <-t.shutdownCh:
+			stream.CancelRead(0)
+			stream.Close()
+			return
+		default:
+		}
+		t.wg.Add(1)
+		go t.dispatchProtocolStream(conn, stream)
+	}
+}
+
+// readProtocolName reads the protocol name a protocol stream starts with.
+func readProtocolName(r io.Reader) (string, error) {
+	var size [1]byte
+	if _, err := io.ReadFull(r, size[:]); err != nil {
+		return "", err
+	}
+	if size[0] == 0 {
+		return "", errors.New("empty protocol name")
+	}
+	name := make([]byte, size[0])
+	if _, err := io.ReadFull(r, name); err != nil {
+		return "", err
+	}
+	return string(name), nil
+}
+
+// dispatchProtocolStream reads the protocol name of an inbound protocol
+// stream and hands the stream to its handler.
+func (t *Transport) dispatchProtocolStream(conn *quic.Conn, stream *quic.Stream) {
+	defer t.wg.Done()
+
+	stream.SetReadDeadline(time.Now().Add(protocolHeaderTimeout))
+	name, err := readProtocolName(stream)
+	handler := t.protocol(name)
+	if err != nil || handler == nil {
+		t.logger.Printf("[WARN] memberlist-quic: unsupported protocol %q from %s", name, conn.RemoteAddr())
+		stream.CancelRead(0)
+		stream.CancelWrite(0)
+		return
+	}
+	stream.SetReadDeadline(time.Time{})
+	handler(conn, stream)
+}
+
+// writeResponse writes a status-prefixed response frame: the result on
+// success, or err's message.
+func writeResponse(w io.Writer, result []byte, err error) error {
+	if err != nil {
+		return writeFrame(w, append([]byte{statusError}, err.Error()...))
+	}
+	return writeFrame(w, append([]byte{statusOK}, result...))
+}
+
+// readResponse reads a frame written by writeResponse.
+func readResponse(r io.Reader) ([]byte, error) {
+	resp, err := readFrame(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(resp) == 0 {
+		return nil, errors.New("empty response")
+	}
+	if resp[0] != statusOK {
+		return nil, errors.New(string(resp[1:]))
+	}
+	return resp[1:], nil
+}
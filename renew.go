@@ -0,0 +1,287 @@
+package memberlistquic
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"slices"
+	"time"
+
+	"github.com/quic-go/quic-go"
+	"github.com/wjordan/memberlist-quic/tlsutil"
+)
+
+const (
+	renewProtocol = "cert-renew/1"
+
+	// renewTimeout bounds a renewal request to one peer.
+	renewTimeout = 10 * time.Second
+
+	defaultRenewCheckInterval = time.Minute
+)
+
+// errRenewConfigForClient is returned when renewing a certificate that a
+// GetConfigForClient override serves to inbound handshakes, where the
+// renewed certificate would not replace it.
+var errRenewConfigForClient = errors.New("cannot renew a certificate served by TLS.GetConfigForClient; renew it at its source instead")
+
+// RenewalConfig configures automatic renewal of the local certificate by a
+// peer running with Config.Issuer. Renewal requests go to peers with an
+// existing authenticated pool connection and keep the certificate's names
+// and addresses; renewed certificates are loaded without reconnecting.
+type RenewalConfig struct {
+	// TTL requested for renewed certificates, which the issuer may cap.
+	// Zero requests the issuer's maximum.
+	TTL time.Duration
+
+	// RenewBefore is how long before the certificate expires to renew it.
+	// Defaults to a third of its lifetime.
+	RenewBefore time.Duration
+
+	// CheckInterval is how often the certificate's expiry is checked.
+	// Defaults to one minute.
+	CheckInterval time.Duration
+
+	// Key selects the algorithm and encoding of the new key generated for
+	// each renewal.
+	Key tlsutil.KeyOptions
+
+	// OnRenew, if set, is called with each renewed certificate chain and
+	// key, encoded as requested by Key, for example to persist them.
+	OnRenew func(certPEM, keyPEM []byte)
+}
+
+// Renew requests a new certificate with the local certificate's names,
+// addresses and roles, signed by this node's Issuer if it has one and
+// otherwise by the first connected peer with one, and loads it into the
+// transport. Existing connections are kept. It is called automatically
+// when Config.Renewal is set. It fails if the TLS config has a
+// GetConfigForClient override, such as tlsutil.Reloadable's, whose
+// certificate must be renewed at its source.
+func (t *Transport) Renew(ctx context.Context) (certPEM, keyPEM []byte, err error) {
+	var renewal RenewalConfig
+	if t.config.Renewal != nil {
+		renewal = *t.config.Renewal
+	}
+
+	if t.tlsConfig.Load().GetConfigForClient != nil {
+		return nil, nil, errRenewConfigForClient
+	}
+	local, err := t.LocalCertificate()
+	if err != nil {
+		return nil, nil, err
+	}
+	csrPEM, keyPEM, err := tlsutil.GenerateCSR(tlsutil.NodeCertOptions{
+		NodeID:   local.Leaf.Subject.CommonName,
+		DNSNames: local.Leaf.DNSNames,
+		IPs:      local.Leaf.IPAddresses,
+		URIs:     local.Leaf.URIs,
+		Key:      renewal.Key,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if t.config.Issuer != nil {
+		certPEM, err = t.config.Issuer.Renew(local.Leaf, csrPEM, renewal.TTL)
+		if err != nil {
+			return nil, nil, err
+		}
+	} else {
+		var errs []error
+		t.pool.Range(func(addr string, conn *quic.Conn) bool {
+			certPEM, err = t.requestRenewal(ctx, addr, peerServerName(conn), csrPEM, renewal.TTL)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", addr, err))
+				return ctx.Err() == nil
+			}
+			return false
+		})
+		if certPEM == nil {
+			if len(errs) == 0 {
+				return nil, nil, errors.New("no connected peers to renew certificate")
+			}
+			return nil, nil, fmt.Errorf("certificate renewal failed: %w", errors.Join(errs...))
+		}
+	}
+
+	tlsKeyPEM := keyPEM
+	if len(renewal.Key.Password) > 0 {
+		if tlsKeyPEM, err = tlsutil.DecryptPrivateKeyPEM(keyPEM, renewal.Key.Password); err != nil {
+			return nil, nil, err
+		}
+	}
+	cert, err := tls.X509KeyPair(certPEM, tlsKeyPEM)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid renewed certificate: %w", err)
+	}
+	err = t.updateTLSConfig(func(current *tls.Config) (*tls.Config, error) {
+		if current.GetConfigForClient != nil {
+			return nil, errRenewConfigForClient
+		}
+		conf := current.Clone()
+		conf.Certificates = []tls.Certificate{cert}
+		conf.GetCertificate = nil
+		conf.GetClientCertificate = nil
+		return conf, nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if renewal.OnRenew != nil {
+		renewal.OnRenew(certPEM, keyPEM)
+	}
+	return certPEM, keyPEM, nil
+}
+
+// requestRenewal asks the peer at addr, verified against serverName, to
+// sign csrPEM.
+func (t *Transport) requestRenewal(ctx context.Context, addr, serverName string, csrPEM []byte, ttl time.Duration) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, renewTimeout)
+	defer cancel()
+	conn, err := t.dialProtocol(ctx, addr, serverName)
+	if err != nil {
+		return nil, err
+	}
+	stream, err := openProtocolStream(ctx, conn, renewProtocol)
+	if err != nil {
+		return nil, err
+	}
+	defer stream.CancelRead(0)
+
+	req := binary.BigEndian.AppendUint64(nil, uint64(ttl))
+	if err := writeFrame(stream, append(req, csrPEM...)); err != nil {
+		return nil, err
+	}
+	stream.Close()
+	return readResponse(stream)
+}
+
+// peerServerName returns a name the certificate of the peer on conn is
+// valid for, or "" to verify it by IP address, so that a peer already
+// authenticated on conn can be dialed again without knowing its
+// memberlist address.
+func peerServerName(conn *quic.Conn) string {
+	certs := conn.ConnectionState().TLS.PeerCertificates
+	if len(certs) > 0 && len(certs[0].DNSNames) > 0 {
+		return certs[0].DNSNames[0]
+	}
+	return ""
+}
+
+// serveRenewal handles a renewal request from a peer.
+func (t *Transport) serveRenewal(conn *quic.Conn, stream *quic.Stream) {
+	defer stream.Close()
+	stream.SetDeadline(time.Now().Add(renewTimeout))
+
+	req, err := readFrame(stream)
+	if err != nil {
+		stream.CancelRead(0)
+		return
+	}
+	certPEM, err := t.issueRenewal(conn, req)
+	if err != nil {
+		t.logger.Printf("[WARN] memberlist-quic: certificate renewal for %s rejected: %v", conn.RemoteAddr(), err)
+	}
+	_ = writeResponse(stream, certPEM, err)
+}
+
+func (t *Transport) issueRenewal(conn *quic.Conn, req []byte) ([]byte, error) {
+	if t.config.Issuer == nil {
+		return nil, errors.New("peer is not an issuer")
+	}
+	if len(req) < 8 {
+		return nil, errors.New("malformed renewal request")
+	}
+	ttl, csrPEM := time.Duration(binary.BigEndian.Uint64(req)), req[8:]
+
+	certs := conn.ConnectionState().TLS.PeerCertificates
+	if len(certs) == 0 {
+		return nil, errors.New("no peer certificate")
+	}
+	csr, err := tlsutil.ParseCSR(csrPEM)
+	if err != nil {
+		return nil, err
+	}
+	if err := sameIdentity(certs[0], csr); err != nil {
+		return nil, err
+	}
+	certPEM, err := t.config.Issuer.Renew(certs[0], csrPEM, ttl)
+	if err != nil {
+		return nil, err
+	}
+	nodeID, _ := tlsutil.NodeIDFromCert(certs[0])
+	t.logger.Printf("[INFO] memberlist-quic: renewed certificate for %s at %s", nodeID, conn.RemoteAddr())
+	return certPEM, nil
+}
+
+// sameIdentity checks that a renewal CSR requests exactly the names and
+// addresses of the peer's current certificate, so that a peer can only
+// renew its own identity.
+func sameIdentity(cert *x509.Certificate, csr *x509.CertificateRequest) error {
+	ipStrings := func(ips []net.IP) []string {
+		s := make([]string, len(ips))
+		for i, ip := range ips {
+			s[i] = ip.String()
+		}
+		return s
+	}
+	uriStrings := func(uris []*url.URL) []string {
+		s := make([]string, len(uris))
+		for i, uri := range uris {
+			s[i] = uri.String()
+		}
+		return s
+	}
+	equal := func(a, b []string) bool {
+		a, b = slices.Clone(a), slices.Clone(b)
+		slices.Sort(a)
+		slices.Sort(b)
+		return slices.Equal(a, b)
+	}
+	if cert.Subject.CommonName != csr.Subject.CommonName ||
+		!equal(cert.DNSNames, csr.DNSNames) ||
+		!equal(ipStrings(cert.IPAddresses), ipStrings(csr.IPAddresses)) ||
+		!equal(uriStrings(cert.URIs), uriStrings(csr.URIs)) {
+		return errors.New("renewal must request the names of the current certificate")
+	}
+	return nil
+}
+
+// renewLoop renews the local certificate when it nears expiry.
+func (t *Transport) renewLoop() {
+	defer t.wg.Done()
+	renewal := t.config.Renewal
+	ticker := time.NewTicker(renewal.CheckInterval)
+	defer ticker.Stop()
+
+	for {
+		if local, err := t.LocalCertificate(); err == nil {
+			before := renewal.RenewBefore
+			if before == 0 {
+				before = local.Leaf.NotAfter.Sub(local.Leaf.NotBefore) / 3
+			}
+			if time.Until(local.Leaf.NotAfter) <= before {
+				ctx, cancel := context.WithTimeout(t.dialContext(), renewTimeout)
+				certPEM, _, err := t.Renew(ctx)
+				cancel()
+				if err != nil {
+					t.logger.Printf("[WARN] memberlist-quic: certificate renewal failed: %v", err)
+				} else if certs, err := tlsutil.ParseCertificates(certPEM); err == nil {
+					t.logger.Printf("[INFO] memberlist-quic: renewed local certificate, now expiring at %s", certs[0].NotAfter.Format(time.RFC3339))
+				}
+			}
+		}
+		select {
+		case <-ticker.C:
+		case <-t.shutdownCh:
+			return
+		}
+	}
+}
@@ -0,0 +1,295 @@
+package memberlistquic
+
+import (
+	"context"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/memberlist"
+	"github.com/wjordan/memberlist-quic/tlsutil"
+	"github.com/wjordan/memberlist-quic/transporttest"
+)
+
+func TestRenewal(t *testing.T) {
+	caCert, caKey, err := tlsutil.GenerateCA("test-org", 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	issuer, err := tlsutil.NewIssuer(caCert, caKey, tlsutil.IssuerPolicy{
+		AllowedNames: []string{"node-*"},
+		MaxTTL:       2 * time.Hour,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// node-1 issues certificates; node-2's certificate is due for renewal
+	var renewed atomic.Int32
+	newNode := func(name string, config Config) (*Transport, *memberlist.Memberlist) {
+		cert, key, err := tlsutil.GenerateNodeCertWithIPs(caCert, caKey, name, []net.IP{net.IPv4(127, 0, 0, 1)}, 10*time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		config.BindAddr = "127.0.0.1"
+		config.TLS, err = tlsutil.MutualTLSConfig(cert, key, caCert)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tr, err := New(config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { _ = tr.Shutdown() })
+
+		cfg := memberlist.DefaultLANConfig()
+		cfg.Name = name
+		cfg.BindAddr = "127.0.0.1"
+		cfg.BindPort = 0
+		cfg.AdvertisePort = 0
+		cfg.Transport = tr
+		cfg.LogOutput = io.Discard
+		ml, err := memberlist.Create(cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { _ = ml.Shutdown() })
+		return tr, ml
+	}
+	_, list1 := newNode("node-1", Config{Issuer: issuer})
+	tr2, list2 := newNode("node-2", Config{
+		Renewal: &RenewalConfig{
+			TTL:           90 * time.Minute,
+			RenewBefore:   time.Hour,
+			CheckInterval: 50 * time.Millisecond,
+			OnRenew:       func(certPEM, keyPEM []byte) { renewed.Add(1) },
+		},
+	})
+	before, err := tr2.LocalCertificate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := list2.Join([]string{advertiseAddr(t, list1)}); err != nil {
+		t.Fatal(err)
+	}
+	waitForMembers(t, 2, list1, list2)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for renewed.Load() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("certificate was not renewed")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	after, err := tr2.LocalCertificate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after.Leaf.SerialNumber.Cmp(before.Leaf.SerialNumber) == 0 || after.NodeID != "node-2" {
+		t.Fatalf("expected a new certificate for node-2, got %s serial %s", after.NodeID, after.Leaf.SerialNumber)
+	}
+	if ttl := time.Until(after.Leaf.NotAfter); ttl < 85*time.Minute || ttl > 90*time.Minute {
+		t.Fatalf("unexpected renewed certificate lifetime %s", ttl)
+	}
+
+	// Once renewed, the certificate is not renewed again on every check
+	time.Sleep(200 * time.Millisecond)
+	if n := renewed.Load(); n != 1 {
+		t.Fatalf("expected 1 renewal, got %d", n)
+	}
+
+	// New connections use the renewed certificate
+	_, list3 := newNode("node-3", Config{})
+	if _, err := list3.Join([]string{advertiseAddr(t, list2)}); err != nil {
+		t.Fatalf("join via renewed node failed: %v", err)
+	}
+	waitForMembers(t, 3, list1, list2, list3)
+}
+
+func TestRenewalKeepsRoles(t *testing.T) {
+	caCert, caKey, err := tlsutil.GenerateCA("test-org", 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	issuer, err := tlsutil.NewIssuer(caCert, caKey, tlsutil.IssuerPolicy{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	policy := RequireRole("member")
+	newTransport := func(name string, issuer *tlsutil.Issuer) *Transport {
+		t.Helper()
+		cert, key, err := tlsutil.GenerateNodeCertWithOptions(caCert, caKey, tlsutil.NodeCertOptions{
+			NodeID:              name,
+			IPs:                 []net.IP{net.IPv4(127, 0, 0, 1)},
+			OrganizationalUnits: []string{"member"},
+			Validity:            time.Hour,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		conf, err := tlsutil.MutualTLSConfig(cert, key, caCert)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tr, err := New(Config{
+			BindAddr:   "127.0.0.1",
+			TLS:        conf,
+			Authorizer: policy,
+			Issuer:     issuer,
+			Logger:     log.New(io.Discard, "", 0),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { _ = tr.Shutdown() })
+		return tr
+	}
+	tr1 := newTransport("node-1", issuer)
+	tr2 := newTransport("node-2", nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := tr2.ConnPool().GetOrDial(ctx, transporttest.Addr(t, tr1)); err != nil {
+		t.Fatal(err)
+	}
+
+	// node-1 renews locally and node-2 through node-1; both keep their role
+	for _, tr := range []*Transport{tr1, tr2} {
+		if _, _, err := tr.Renew(ctx); err != nil {
+			t.Fatalf("renewal failed: %v", err)
+		}
+		info, err := tr.LocalCertificate()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := policy(info.Chain); err != nil {
+			t.Fatalf("renewed certificate of %s: %v", info.NodeID, err)
+		}
+	}
+
+	// New connections with the renewed certificates are authorized
+	tr3 := newTransport("node-3", nil)
+	for _, tr := range []*Transport{tr1, tr2} {
+		if _, err := tr3.ConnPool().GetOrDial(ctx, transporttest.Addr(t, tr)); err != nil {
+			t.Fatalf("dial with renewed certificate: %v", err)
+		}
+	}
+}
+
+func TestRenewalConfigForClient(t *testing.T) {
+	caCert, caKey, err := tlsutil.GenerateCA("test-org", 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	issuer, err := tlsutil.NewIssuer(caCert, caKey, tlsutil.IssuerPolicy{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, key, err := tlsutil.GenerateNodeCertWithIPs(caCert, caKey, "node-1", []net.IP{net.IPv4(127, 0, 0, 1)}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reloadable, err := tlsutil.NewReloadable(cert, key, caCert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := Config{
+		BindAddr: "127.0.0.1",
+		TLS:      reloadable.Config(),
+		Issuer:   issuer,
+		Logger:   log.New(io.Discard, "", 0),
+		Renewal:  &RenewalConfig{},
+	}
+
+	// Automatic renewal is refused up front
+	if _, err := New(config); err == nil {
+		t.Fatal("expected Renewal with GetConfigForClient to be rejected")
+	}
+
+	// On-demand renewal fails without loading a certificate that inbound
+	// handshakes would never present
+	config.Renewal = nil
+	tr, err := New(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = tr.Shutdown() })
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, _, err := tr.Renew(ctx); err == nil || !strings.Contains(err.Error(), "GetConfigForClient") {
+		t.Fatalf("expected renewal to be refused, got %v", err)
+	}
+	if conf := tr.tlsConfig.Load(); len(conf.Certificates) != 0 || conf.GetCertificate == nil {
+		t.Fatal("refused renewal replaced the certificate")
+	}
+}
+
+func TestRenewalIdentity(t *testing.T) {
+	caCert, caKey, err := tlsutil.GenerateCA("test-org", 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	issuer, err := tlsutil.NewIssuer(caCert, caKey, tlsutil.IssuerPolicy{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, key, err := tlsutil.GenerateNodeCertWithIPs(caCert, caKey, "node-1", []net.IP{net.IPv4(127, 0, 0, 1)}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tlsConf, err := tlsutil.MutualTLSConfig(cert, key, caCert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr1, err := New(Config{BindAddr: "127.0.0.1", TLS: tlsConf, Issuer: issuer})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = tr1.Shutdown() })
+	tr2, _ := createTestTransport(t, caCert, caKey, "node-2")
+
+	ip, port, err := tr1.FinalAdvertiseAddr("", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	addr := (&net.UDPAddr{IP: ip, Port: port}).String()
+
+	// A peer cannot obtain a certificate for another identity
+	csr, _, err := tlsutil.GenerateCSR(tlsutil.NodeCertOptions{NodeID: "node-1", IPs: []net.IP{net.IPv4(127, 0, 0, 1)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tr2.requestRenewal(ctx, addr, "", csr, 0); err == nil || !strings.Contains(err.Error(), "names of the current certificate") {
+		t.Fatalf("expected renewal for another identity to be rejected, got %v", err)
+	}
+
+	// Its own identity is renewed
+	csr, _, err = tlsutil.GenerateCSR(tlsutil.NodeCertOptions{NodeID: "node-2", DNSNames: []string{"node-2"}, IPs: []net.IP{net.IPv4(127, 0, 0, 1)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tr2.requestRenewal(ctx, addr, "", csr, 0); err != nil {
+		t.Fatalf("renewal failed: %v", err)
+	}
+
+	// Streams for unknown protocols are reset
+	conn, err := tr2.dialProtocol(ctx, addr, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream, err := openProtocolStream(ctx, conn, "unknown/1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream.Close()
+	if _, err := io.ReadAll(stream); err == nil {
+		t.Fatal("expected unknown protocol stream to be reset")
+	}
+}
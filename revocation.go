@@ -0,0 +1,235 @@
+package memberlistquic
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/hashicorp/memberlist"
+	"github.com/wjordan/memberlist-quic/tlsutil"
+)
+
+// revocationMagic prefixes revocation gossip messages and push/pull state
+// so they can be told apart from those of the wrapped delegate.
+const revocationMagic = "\xf1crl"
+
+// RevocationGossipConfig configures a RevocationGossip.
+type RevocationGossipConfig struct {
+	// Revocation receives CRLs from peers. Required.
+	Revocation *tlsutil.Revocation
+
+	// CACert is the PEM bundle of CAs whose CRLs are accepted. Required.
+	CACert []byte
+
+	// NumNodes returns the current cluster size, typically
+	// Memberlist.NumMembers. Required.
+	NumNodes func() int
+
+	// Transport, if set, has its connections to revoked peers closed
+	// whenever a new CRL is applied.
+	Transport *Transport
+
+	// Delegate, if set, receives all user messages, broadcasts and state
+	// not belonging to revocation gossip.
+	Delegate memberlist.Delegate
+
+	// RetransmitMult controls how many times each CRL is gossiped.
+	// Defaults to memberlist's default LAN value.
+	RetransmitMult int
+
+	Logger *log.Logger
+}
+
+// RevocationGossip is a memberlist.Delegate that spreads CRLs through the
+// cluster. CRLs are signed by the CA, so every node verifies them itself
+// and a peer cannot forge or roll back revocations. New CRLs are gossiped
+// as broadcasts, and the full set is exchanged during push/pull so that
+// joining nodes and nodes that missed a broadcast catch up.
+//
+// CRLs larger than a gossip packet (roughly a thousand bytes, a few dozen
+// entries) only spread through push/pull.
+type RevocationGossip struct {
+	config     RevocationGossipConfig
+	broadcasts *memberlist.TransmitLimitedQueue
+	logger     *log.Logger
+}
+
+// NewRevocationGossip creates a RevocationGossip. Set it as the
+// memberlist.Config's Delegate.
+func NewRevocationGossip(config RevocationGossipConfig) (*RevocationGossip, error) {
+	if config.Revocation == nil {
+		return nil, errors.New("revocation is required")
+	}
+	if _, err := tlsutil.ParseCertificates(config.CACert); err != nil {
+		return nil, fmt.Errorf("invalid CA certificate: %w", err)
+	}
+	if config.NumNodes == nil {
+		return nil, errors.New("NumNodes is required")
+	}
+	if config.RetransmitMult == 0 {
+		config.RetransmitMult = memberlist.DefaultLANConfig().RetransmitMult
+	}
+
+	if config.Logger == nil {
+		config.Logger = log.Default()
+	}
+	logger := config.Logger
+
+	g := &RevocationGossip{
+		config: config,
+		broadcasts: &memberlist.TransmitLimitedQueue{
+			NumNodes:       config.NumNodes,
+			RetransmitMult: config.RetransmitMult,
+		},
+		logger: logger,
+	}
+	if tr := config.Transport; tr != nil {
+		config.Revocation.OnUpdate(func() {
+			if n := tr.CloseRevoked(config.Revocation.IsRevoked); n > 0 {
+				logger.Printf("[INFO] memberlist-quic: closed %d connections to revoked peers", n)
+			}
+		})
+	}
+	return g, nil
+}
+
+// Publish applies a PEM-encoded CRL locally and gossips it to the cluster.
+func (g *RevocationGossip) Publish(crlPEM []byte) error {
+	if err := g.config.Revocation.Update(crlPEM, g.config.CACert); err != nil {
+		return err
+	}
+	g.queue(crlPEM)
+	return nil
+}
+
+// receive applies a CRL from a peer, regossiping it if it was new.
+func (g *RevocationGossip) receive(der []byte) {
+	crlPEM := pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: der})
+	updated, err := g.config.Revocation.Add(crlPEM, g.config.CACert)
+	if err != nil {
+		g.logger.Printf("[WARN] memberlist-quic: rejected gossiped CRL: %v", err)
+		return
+	}
+	if updated {
+		g.queue(crlPEM)
+	}
+}
+
+func (g *RevocationGossip) queue(crlPEM []byte) {
+	block, _ := pem.Decode(crlPEM)
+	crl, err := tlsutil.ParseCRL(crlPEM, g.config.CACert)
+	if err != nil || block == nil {
+		return
+	}
+	g.broadcasts.QueueBroadcast(&crlBroadcast{
+		issuer: string(crl.RawIssuer),
+		msg:    append([]byte(revocationMagic), block.Bytes...),
+	})
+}
+
+// NodeMeta implements memberlist.Delegate.
+func (g *RevocationGossip) NodeMeta(limit int) []byte {
+	if g.config.Delegate == nil {
+		return nil
+	}
+	return g.config.Delegate.NodeMeta(limit)
+}
+
+// NotifyMsg implements memberlist.Delegate.
+func (g *RevocationGossip) NotifyMsg(msg []byte) {
+	if der, ok := bytes.CutPrefix(msg, []byte(revocationMagic)); ok {
+		g.receive(der)
+		return
+	}
+	if g.config.Delegate != nil {
+		g.config.Delegate.NotifyMsg(msg)
+	}
+}
+
+// GetBroadcasts implements memberlist.Delegate.
+func (g *RevocationGossip) GetBroadcasts(overhead, limit int) [][]byte {
+	msgs := g.broadcasts.GetBroadcasts(overhead, limit)
+	if g.config.Delegate == nil {
+		return msgs
+	}
+	for _, msg := range msgs {
+		limit -= overhead + len(msg)
+	}
+	return append(msgs, g.config.Delegate.GetBroadcasts(overhead, limit)...)
+}
+
+// LocalState implements memberlist.Delegate. The state is the revocation
+// magic, the number of CRLs, each DER-encoded CRL prefixed with its
+// length as uvarints, and then the wrapped delegate's state.
+func (g *RevocationGossip) LocalState(join bool) []byte {
+	crls := g.config.Revocation.CRLs()
+	buf := []byte(revocationMagic)
+	buf = binary.AppendUvarint(buf, uint64(len(crls)))
+	for _, crlPEM := range crls {
+		block, _ := pem.Decode(crlPEM)
+		buf = binary.AppendUvarint(buf, uint64(len(block.Bytes)))
+		buf = append(buf, block.Bytes...)
+	}
+	if g.config.Delegate != nil {
+		buf = append(buf, g.config.Delegate.LocalState(join)...)
+	}
+	return buf
+}
+
+// MergeRemoteState implements memberlist.Delegate.
+func (g *RevocationGossip) MergeRemoteState(buf []byte, join bool) {
+	if rest, ok := bytes.CutPrefix(buf, []byte(revocationMagic)); ok {
+		crls, rest, err := decodeRevocationState(rest)
+		if err != nil {
+			g.logger.Printf("[WARN] memberlist-quic: invalid revocation state: %v", err)
+			return
+		}
+		for _, der := range crls {
+			g.receive(der)
+		}
+		buf = rest
+	}
+	if g.config.Delegate != nil {
+		g.config.Delegate.MergeRemoteState(buf, join)
+	}
+}
+
+// decodeRevocationState splits push/pull state into CRLs and the state
+// remaining for the wrapped delegate.
+func decodeRevocationState(buf []byte) (crls [][]byte, rest []byte, err error) {
+	count, n := binary.Uvarint(buf)
+	if n <= 0 {
+		return nil, nil, errors.New("truncated CRL count")
+	}
+	buf = buf[n:]
+	for i := uint64(0); i < count; i++ {
+		size, n := binary.Uvarint(buf)
+		if n <= 0 || size > uint64(len(buf)-n) {
+			return nil, nil, fmt.Errorf("truncated CRL %d", i+1)
+		}
+		buf = buf[n:]
+		crls = append(crls, buf[:size])
+		buf = buf[size:]
+	}
+	return crls, buf, nil
+}
+
+// crlBroadcast gossips a CRL, superseding any queued CRL from the same CA.
+type crlBroadcast struct {
+	issuer string
+	msg    []byte
+}
+
+func (b *crlBroadcast) Invalidates(other memberlist.Broadcast) bool {
+	o, ok := other.(*crlBroadcast)
+	return ok && o.issuer == b.issuer
+}
+
+func (b *crlBroadcast) Message() []byte {
+	return b.msg
+}
+
+func (b *crlBroadcast) Finished() {}
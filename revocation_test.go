@@ -0,0 +1,145 @@
+package memberlistquic
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/memberlist"
+	"github.com/wjordan/memberlist-quic/tlsutil"
+)
+
+func TestRevocationGossip(t *testing.T) {
+	caCert, caKey, err := tlsutil.GenerateCA("test-org", 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type node struct {
+		list   *memberlist.Memberlist
+		rev    *tlsutil.Revocation
+		gossip *RevocationGossip
+		cert   []byte
+	}
+	nodes := make([]*node, 3)
+	for i := range nodes {
+		name := fmt.Sprintf("node-%d", i+1)
+		cert, key, err := tlsutil.GenerateNodeCertWithIPs(caCert, caKey, name, []net.IP{net.IPv4(127, 0, 0, 1)}, time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+		conf, err := tlsutil.MutualTLSConfig(cert, key, caCert)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rev := tlsutil.NewRevocation()
+		logger := log.New(io.Discard, "", 0)
+		tr, err := New(Config{BindAddr: "127.0.0.1", TLS: rev.Apply(conf), Logger: logger})
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { _ = tr.Shutdown() })
+
+		n := &node{rev: rev, cert: cert}
+		n.gossip, err = NewRevocationGossip(RevocationGossipConfig{
+			Revocation: rev,
+			CACert:     caCert,
+			NumNodes:   func() int { return n.list.NumMembers() },
+			Transport:  tr,
+			Logger:     logger,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		cfg := memberlist.DefaultLANConfig()
+		cfg.Name = name
+		cfg.BindAddr = "127.0.0.1"
+		cfg.BindPort = 0
+		cfg.AdvertisePort = 0
+		cfg.Transport = tr
+		cfg.Delegate = n.gossip
+		cfg.LogOutput = io.Discard
+		cfg.GossipInterval = 50 * time.Millisecond
+		cfg.ProbeInterval = 200 * time.Millisecond
+		cfg.ProbeTimeout = 100 * time.Millisecond
+		cfg.SuspicionMult = 2
+		n.list, err = memberlist.Create(cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { _ = n.list.Shutdown() })
+		nodes[i] = n
+	}
+	for _, n := range nodes[1:] {
+		if _, err := n.list.Join([]string{advertiseAddr(t, nodes[0].list)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitForMembers(t, 3, nodes[0].list, nodes[1].list, nodes[2].list)
+
+	// A CRL from an untrusted CA is not accepted for publishing
+	otherCA, otherKey, err := tlsutil.GenerateCA("other-org", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	forged, err := tlsutil.GenerateCRL(otherCA, otherKey, nil, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := nodes[0].gossip.Publish(forged); err == nil {
+		t.Fatal("expected CRL from an untrusted CA to be rejected")
+	}
+
+	revoked, err := tlsutil.ParseCertificates(nodes[2].cert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	crl, err := tlsutil.GenerateCRL(caCert, caKey, []*big.Int{revoked[0].SerialNumber}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := nodes[0].gossip.Publish(crl); err != nil {
+		t.Fatal(err)
+	}
+
+	// The CRL reaches node-2 by gossip, and both remaining nodes evict
+	// node-3
+	deadline := time.Now().Add(5 * time.Second)
+	for !nodes[1].rev.IsRevoked(revoked[0]) {
+		if time.Now().After(deadline) {
+			t.Fatal("CRL was not gossiped to node-2")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	waitForMembers(t, 2, nodes[0].list, nodes[1].list)
+}
+
+func TestDecodeRevocationState(t *testing.T) {
+	g := &RevocationGossip{config: RevocationGossipConfig{Revocation: tlsutil.NewRevocation()}}
+	state := g.LocalState(false)
+	crls, rest, err := decodeRevocationState(bytes.TrimPrefix(state, []byte(revocationMagic)))
+	if err != nil || len(crls) != 0 || len(rest) != 0 {
+		t.Fatalf("unexpected empty state decode: %v %v %v", crls, rest, err)
+	}
+
+	buf := []byte{2, 3, 'a', 'b', 'c', 1, 'd', 'x', 'y'}
+	crls, rest, err = decodeRevocationState(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(crls) != 2 || string(crls[0]) != "abc" || string(crls[1]) != "d" || string(rest) != "xy" {
+		t.Fatalf("unexpected decode: %q %q", crls, rest)
+	}
+
+	for _, bad := range [][]byte{nil, {1}, {1, 5, 'a'}, {2, 1, 'a'}} {
+		if _, _, err := decodeRevocationState(bad); err == nil {
+			t.Fatalf("expected error decoding %v", bad)
+		}
+	}
+}
@@ -0,0 +1,278 @@
+package memberlistquic
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/memberlist"
+	"github.com/quic-go/quic-go"
+)
+
+const (
+	rpcProtocol = "rpc/1"
+
+	defaultRPCMaxMessageSize = 1 << 20
+)
+
+// RPCErrorCode classifies why a call failed.
+type RPCErrorCode uint8
+
+const (
+	// RPCHandlerFailed means the handler returned an error.
+	RPCHandlerFailed RPCErrorCode = iota + 1
+	// RPCUnknownMethod means the node has no handler for the method.
+	RPCUnknownMethod
+	// RPCTooLarge means the request or response exceeded a node's
+	// MaxMessageSize.
+	RPCTooLarge
+	// RPCBusy means the method was already serving its maximum number of
+	// concurrent calls. The call was not attempted and may be retried.
+	RPCBusy
+)
+
+// RPCError is returned by RPC.Call when the call reached the node but did
+// not succeed. Other errors, such as dial failures and context errors,
+// are returned as they are.
+type RPCError struct {
+	Method  string
+	Code    RPCErrorCode
+	Message string
+}
+
+func (e *RPCError) Error() string {
+	return fmt.Sprintf("rpc %s: %s", e.Method, e.Message)
+}
+
+// RPCHandler serves a call to a method. caller describes the certificate
+// the calling node authenticated with. ctx is canceled when the caller's
+// deadline passes, the caller gives up, or the transport shuts down.
+type RPCHandler func(ctx context.Context, caller CertificateInfo, req []byte) ([]byte, error)
+
+// RPCConfig configures an RPC subsystem.
+type RPCConfig struct {
+	// MaxMessageSize is the largest request or response payload in
+	// bytes. Defaults to 1 MiB.
+	MaxMessageSize int
+}
+
+// RPC is a request/response layer over the transport's pooled
+// connections. Each call opens its own stream, carrying the method name,
+// the caller's remaining deadline and the request. Create one with
+// Transport.NewRPC.
+type RPC struct {
+	t       *Transport
+	config  RPCConfig
+	methods map[string]*rpcMethod
+	mu      sync.RWMutex
+}
+
+type rpcMethod struct {
+	handler RPCHandler
+	sem     chan struct{}
+}
+
+// NewRPC enables RPC on the transport. Only one RPC subsystem may be
+// enabled per transport, and nodes serve only the methods registered on
+// it.
+func (t *Transport) NewRPC(config RPCConfig) (*RPC, error) {
+	if config.MaxMessageSize <= 0 {
+		config.MaxMessageSize = defaultRPCMaxMessageSize
+	}
+	r := &RPC{
+		t:       t,
+		config:  config,
+		methods: make(map[string]*rpcMethod),
+	}
+
+	t.protocolsMu.Lock()
+	defer t.protocolsMu.Unlock()
+	if _, ok := t.protocols[rpcProtocol]; ok {
+		return nil, errors.New("RPC is already enabled on this transport")
+	}
+	t.protocols[rpcProtocol] = r.serve
+	return r, nil
+}
+
+// Register serves method with handler. If maxConcurrent is positive,
+// calls beyond that many in flight fail with RPCBusy.
+func (r *RPC) Register(method string, maxConcurrent int, handler RPCHandler) error {
+	if method == "" || len(method) > 255 {
+		return fmt.Errorf("invalid method name %q", method)
+	}
+	m := &rpcMethod{handler: handler}
+	if maxConcurrent > 0 {
+		m.sem = make(chan struct{}, maxConcurrent)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.methods[method]; ok {
+		return fmt.Errorf("method %q is already registered", method)
+	}
+	r.methods[method] = m
+	return nil
+}
+
+// Call invokes method on the node at addr with req and returns its
+// response. The node's certificate is verified against Config.ServerName
+// for addr, as for memberlist traffic, so with NodeNameServerName the
+// call only reaches the node named addr.Name. ctx's deadline is passed to
+// the handler.
+func (r *RPC) Call(ctx context.Context, addr memberlist.Address, method string, req []byte) ([]byte, error) {
+	if len(req) > r.config.MaxMessageSize {
+		return nil, &RPCError{
+			Method:  method,
+			Code:    RPCTooLarge,
+			Message: fmt.Sprintf("request of %d bytes exceeds maximum of %d", len(req), r.config.MaxMessageSize),
+		}
+	}
+	conn, err := r.t.dialProtocol(ctx, addr.Addr, r.t.serverName(addr))
+	if err != nil {
+		return nil, err
+	}
+	stream, err := openProtocolStream(ctx, conn, rpcProtocol)
+	if err != nil {
+		return nil, err
+	}
+
+	// Cancellation resets the stream, which cancels the handler's context
+	stream.SetDeadline(time.Time{})
+	stop := context.AfterFunc(ctx, func() {
+		stream.CancelRead(0)
+		stream.CancelWrite(0)
+	})
+	defer stop()
+
+	resp, err := r.roundTrip(ctx, stream, method, req)
+	if err != nil && ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	return resp, err
+}
+
+// CallNode is like Call for a memberlist node.
+func (r *RPC) CallNode(ctx context.Context, node *memberlist.Node, method string, req []byte) ([]byte, error) {
+	return r.Call(ctx, memberlist.Address{Addr: node.Address(), Name: node.Name}, method, req)
+}
+
+func (r *RPC) roundTrip(ctx context.Context, stream *quic.Stream, method string, req []byte) ([]byte, error) {
+	hdr := make([]byte, 8, 8+len(method))
+	if deadline, ok := ctx.Deadline(); ok {
+		binary.BigEndian.PutUint64(hdr, uint64(max(time.Until(deadline), 1)))
+	}
+	hdr = append(hdr, method...)
+	werr := writeFrame(stream, hdr)
+	if werr == nil {
+		_, werr = stream.Write(req)
+	}
+	stream.Close()
+
+	// A node rejecting the request may reset the stream before reading
+	// all of it, so its response takes precedence over write errors
+	resp, err := io.ReadAll(io.LimitReader(stream, int64(r.config.MaxMessageSize)+2))
+	if err != nil || len(resp) == 0 {
+		stream.CancelRead(0)
+		if werr != nil {
+			return nil, werr
+		}
+		if err == nil {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	if len(resp)-1 > r.config.MaxMessageSize {
+		stream.CancelRead(0)
+		return nil, &RPCError{
+			Method:  method,
+			Code:    RPCTooLarge,
+			Message: fmt.Sprintf("response exceeds maximum of %d bytes", r.config.MaxMessageSize),
+		}
+	}
+	if resp[0] != statusOK {
+		return nil, &RPCError{Method: method, Code: RPCErrorCode(resp[0]), Message: string(resp[1:])}
+	}
+	return resp[1:], nil
+}
+
+// serve handles an inbound call. The request header must arrive within
+// protocolHeaderTimeout; the body may take until the caller's deadline,
+// or indefinitely if it has none.
+func (r *RPC) serve(conn *quic.Conn, stream *quic.Stream) {
+	stream.SetReadDeadline(time.Now().Add(protocolHeaderTimeout))
+	hdr, err := readFrame(stream)
+	if err != nil || len(hdr) < 8 {
+		stream.CancelRead(0)
+		stream.CancelWrite(0)
+		return
+	}
+	timeout := time.Duration(binary.BigEndian.Uint64(hdr[:8]))
+	method := string(hdr[8:])
+	if timeout > 0 {
+		stream.SetReadDeadline(time.Now().Add(timeout))
+	} else {
+		stream.SetReadDeadline(time.Time{})
+	}
+	req, err := io.ReadAll(io.LimitReader(stream, int64(r.config.MaxMessageSize)+1))
+	if err != nil {
+		stream.CancelRead(0)
+		stream.CancelWrite(0)
+		return
+	}
+
+	// The stream's context is canceled if the caller resets it
+	ctx, cancel := context.WithCancel(stream.Context())
+	defer cancel()
+	stop := context.AfterFunc(r.t.dialContext(), cancel)
+	defer stop()
+	if timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	resp, code, msg := r.handle(ctx, conn, method, req)
+	if code != statusOK {
+		stream.CancelRead(0)
+		resp = []byte(msg)
+	}
+	if _, err := stream.Write(append([]byte{code}, resp...)); err != nil {
+		stream.CancelWrite(0)
+		return
+	}
+	stream.Close()
+}
+
+// handle runs the handler for a call, returning the response or an error
+// code and message.
+func (r *RPC) handle(ctx context.Context, conn *quic.Conn, method string, req []byte) ([]byte, byte, string) {
+	r.mu.RLock()
+	m := r.methods[method]
+	r.mu.RUnlock()
+	if m == nil {
+		return nil, byte(RPCUnknownMethod), fmt.Sprintf("unknown method %q", method)
+	}
+	if len(req) > r.config.MaxMessageSize {
+		return nil, byte(RPCTooLarge), fmt.Sprintf("request exceeds maximum of %d bytes", r.config.MaxMessageSize)
+	}
+	if m.sem != nil {
+		select {
+		case m.sem <- struct{}{}:
+			defer func() { <-m.sem }()
+		default:
+			return nil, byte(RPCBusy), fmt.Sprintf("too many concurrent calls (limit %d)", cap(m.sem))
+		}
+	}
+
+	resp, err := m.handler(ctx, peerInfo(conn), req)
+	if err != nil {
+		return nil, byte(RPCHandlerFailed), err.Error()
+	}
+	if len(resp) > r.config.MaxMessageSize {
+		return nil, byte(RPCTooLarge), fmt.Sprintf("response of %d bytes exceeds maximum of %d", len(resp), r.config.MaxMessageSize)
+	}
+	return resp, statusOK, ""
+}
@@ -0,0 +1,142 @@
+package memberlistquic
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/memberlist"
+	"github.com/wjordan/memberlist-quic/tlsutil"
+)
+
+func TestRPC(t *testing.T) {
+	caCert, caKey, err := tlsutil.GenerateCA("test-org", 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server, _ := createTestTransport(t, caCert, caKey, "node-1")
+	client, _ := createTestTransport(t, caCert, caKey, "node-2")
+
+	serverRPC, err := server.NewRPC(RPCConfig{MaxMessageSize: 1024})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := server.NewRPC(RPCConfig{}); err == nil {
+		t.Fatal("expected error enabling RPC twice")
+	}
+	clientRPC, err := client.NewRPC(RPCConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	started := make(chan struct{}, 1)
+	canceled := make(chan error, 1)
+	release := make(chan struct{})
+	register := func(method string, maxConcurrent int, handler RPCHandler) {
+		if err := serverRPC.Register(method, maxConcurrent, handler); err != nil {
+			t.Fatal(err)
+		}
+	}
+	register("echo", 0, func(ctx context.Context, caller CertificateInfo, req []byte) ([]byte, error) {
+		return append([]byte(caller.NodeID+": "), req...), nil
+	})
+	register("fail", 0, func(context.Context, CertificateInfo, []byte) ([]byte, error) {
+		return nil, errors.New("something broke")
+	})
+	register("big", 0, func(context.Context, CertificateInfo, []byte) ([]byte, error) {
+		return make([]byte, 2048), nil
+	})
+	register("slow", 1, func(ctx context.Context, _ CertificateInfo, _ []byte) ([]byte, error) {
+		started <- struct{}{}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-release:
+			return []byte("done"), nil
+		}
+	})
+	register("hang", 0, func(ctx context.Context, _ CertificateInfo, _ []byte) ([]byte, error) {
+		<-ctx.Done()
+		canceled <- ctx.Err()
+		return nil, ctx.Err()
+	})
+	if err := serverRPC.Register("echo", 0, nil); err == nil {
+		t.Fatal("expected error registering a method twice")
+	}
+
+	_, port, err := server.FinalAdvertiseAddr("", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	node := &memberlist.Node{Name: "node-1", Addr: net.IPv4(127, 0, 0, 1), Port: uint16(port)}
+	addr := memberlist.Address{Addr: "127.0.0.1:" + strconv.Itoa(port), Name: "node-1"}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	resp, err := clientRPC.CallNode(ctx, node, "echo", []byte("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(resp) != "node-2: hello" {
+		t.Fatalf("unexpected response %q", resp)
+	}
+
+	expectCode := func(err error, code RPCErrorCode) {
+		t.Helper()
+		var rpcErr *RPCError
+		if !errors.As(err, &rpcErr) || rpcErr.Code != code {
+			t.Fatalf("expected RPC error code %d, got %v", code, err)
+		}
+	}
+	_, err = clientRPC.Call(ctx, addr, "missing", nil)
+	expectCode(err, RPCUnknownMethod)
+	_, err = clientRPC.Call(ctx, addr, "fail", nil)
+	expectCode(err, RPCHandlerFailed)
+	if err.Error() != "rpc fail: something broke" {
+		t.Errorf("unexpected error message: %v", err)
+	}
+	_, err = clientRPC.Call(ctx, addr, "echo", make([]byte, 64*1024))
+	expectCode(err, RPCTooLarge)
+	_, err = clientRPC.Call(ctx, addr, "big", nil)
+	expectCode(err, RPCTooLarge)
+
+	// Calls beyond the concurrency limit are rejected
+	done := make(chan error, 1)
+	go func() {
+		resp, err := clientRPC.Call(ctx, addr, "slow", nil)
+		if err == nil && string(resp) != "done" {
+			err = errors.New("unexpected response " + string(resp))
+		}
+		done <- err
+	}()
+	<-started
+	_, err = clientRPC.Call(ctx, addr, "slow", nil)
+	expectCode(err, RPCBusy)
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	// The caller's deadline cancels the handler
+	shortCtx, shortCancel := context.WithTimeout(ctx, 200*time.Millisecond)
+	defer shortCancel()
+	if _, err := clientRPC.Call(shortCtx, addr, "hang", nil); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+	select {
+	case err := <-canceled:
+		if !errors.Is(err, context.DeadlineExceeded) && !errors.Is(err, context.Canceled) {
+			t.Fatalf("unexpected handler context error %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("handler context was not canceled")
+	}
+
+	if n := client.protoPool.Len(); n != 1 {
+		t.Fatalf("expected calls to share the pooled connection, got %d connections", n)
+	}
+}
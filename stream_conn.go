@@ -1,6 +1,7 @@
 package memberlistquic
 
 import (
+	"crypto/tls"
 	"net"
 	"time"
 
@@ -9,6 +10,7 @@ import (
 
 // quicStreamConn wraps a quic.Stream to implement net.Conn.
 type quicStreamConn struct {
+	conn       *quic.Conn
 	stream     *quic.Stream
 	localAddr  net.Addr
 	remoteAddr net.Addr
@@ -16,6 +18,15 @@ type quicStreamConn struct {
 
 var _ net.Conn = (*quicStreamConn)(nil)
 
+func newStreamConn(conn *quic.Conn, stream *quic.Stream) *quicStreamConn {
+	return &quicStreamConn{
+		conn:       conn,
+		stream:     stream,
+		localAddr:  conn.LocalAddr(),
+		remoteAddr: newPeerAddr(conn),
+	}
+}
+
 func (c *quicStreamConn) Read(b []byte) (int, error)  { return c.stream.Read(b) }
 func (c *quicStreamConn) Write(b []byte) (int, error) { return c.stream.Write(b) }
 func (c *quicStreamConn) Close() error                { return c.stream.Close() }
@@ -33,3 +44,15 @@ func (c *quicStreamConn) SetReadDeadline(t time.Time) error {
 func (c *quicStreamConn) SetWriteDeadline(t time.Time) error {
 	return c.stream.SetWriteDeadline(t)
 }
+
+// ConnectionState returns the TLS state of the connection the stream
+// belongs to, like tls.Conn, so that the peer's verified certificates can
+// be inspected.
+func (c *quicStreamConn) ConnectionState() tls.ConnectionState {
+	return c.conn.ConnectionState().TLS
+}
+
+// QUICConn returns the connection the stream belongs to.
+func (c *quicStreamConn) QUICConn() *quic.Conn {
+	return c.conn
+}
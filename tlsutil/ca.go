@@ -0,0 +1,179 @@
+package tlsutil
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+)
+
+// ParseCertificates parses every CERTIFICATE block in a PEM bundle. It
+// fails if the bundle contains no certificates or any block is invalid,
+// so that a corrupt bundle is not silently truncated.
+func ParseCertificates(bundlePEM []byte) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	rest := bundlePEM
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("certificate %d: %w", len(certs)+1, err)
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return nil, errors.New("no certificates found in PEM bundle")
+	}
+	return certs, nil
+}
+
+// CertPoolFromPEM builds a pool from a PEM bundle of one or more trusted
+// CA certificates. During a CA rollover the bundle holds both the old and
+// new CA.
+func CertPoolFromPEM(bundlePEM []byte) (*x509.CertPool, error) {
+	certs, err := ParseCertificates(bundlePEM)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse CA certificate: %w", err)
+	}
+	pool := x509.NewCertPool()
+	for _, cert := range certs {
+		pool.AddCert(cert)
+	}
+	return pool, nil
+}
+
+// BundlePEM concatenates PEM-encoded certificates into a single bundle.
+func BundlePEM(pems ...[]byte) []byte {
+	var buf bytes.Buffer
+	for _, p := range pems {
+		buf.Write(bytes.TrimSpace(p))
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes()
+}
+
+// GenerateIntermediateCA creates a CA certificate signed by the given
+// parent CA. The returned certificate PEM includes the parent's chain
+// (excluding the root), so it can be passed directly as the CA to
+// GenerateNodeCert and node certificates will carry the full chain.
+func GenerateIntermediateCA(parentCertPEM, parentKeyPEM []byte, org string, validity time.Duration) (certPEM, keyPEM []byte, err error) {
+	parentCert, parentKey, err := parseCA(parentCertPEM, parentKeyPEM)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	certPEM, keyPEM, err = GenerateCA(org, validity)
+	if err != nil {
+		return nil, nil, err
+	}
+	selfSigned, key, err := parseCA(certPEM, keyPEM)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	template := caTemplate(selfSigned)
+	template.SerialNumber, err = newSerial()
+	if err != nil {
+		return nil, nil, err
+	}
+	certDER, err := x509.CreateCertificate(rand.Reader, template, parentCert, key.Public(), parentKey)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	chain, err := issuerChainPEM(parentCertPEM)
+	if err != nil {
+		return nil, nil, err
+	}
+	certPEM = append(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER}), chain...)
+	return certPEM, keyPEM, nil
+}
+
+// CrossSignCA issues a certificate for the subject CA (typically a
+// successor created with GenerateCA) signed by the issuer CA. The result
+// has the subject's name and public key, so certificates signed by the
+// successor also chain to the issuer through it. Nodes still trusting
+// only the issuer accept successor-signed peers whose certificate chain
+// includes the cross-signed certificate.
+func CrossSignCA(issuerCertPEM, issuerKeyPEM, subjectCertPEM []byte) ([]byte, error) {
+	issuerCert, issuerKey, err := parseCA(issuerCertPEM, issuerKeyPEM)
+	if err != nil {
+		return nil, err
+	}
+	subjects, err := ParseCertificates(subjectCertPEM)
+	if err != nil {
+		return nil, err
+	}
+	subject := subjects[0]
+	if !subject.IsCA {
+		return nil, errors.New("subject certificate is not a CA")
+	}
+
+	template := caTemplate(subject)
+	template.SerialNumber, err = newSerial()
+	if err != nil {
+		return nil, err
+	}
+	// A cross-signed certificate cannot outlive its issuer
+	if template.NotAfter.After(issuerCert.NotAfter) {
+		template.NotAfter = issuerCert.NotAfter
+	}
+
+	certDER, err := x509.CreateCertificate(rand.Reader, template, issuerCert, subject.PublicKey, issuerKey)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER}), nil
+}
+
+// caTemplate returns a CA template carrying over the name, key identifier
+// and validity of an existing CA certificate.
+func caTemplate(cert *x509.Certificate) *x509.Certificate {
+	return &x509.Certificate{
+		Subject:               pkix.Name{Organization: cert.Subject.Organization, CommonName: cert.Subject.CommonName},
+		SubjectKeyId:          cert.SubjectKeyId,
+		NotBefore:             cert.NotBefore,
+		NotAfter:              cert.NotAfter,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+}
+
+// issuerChainPEM returns the certificates of a CA bundle that should be
+// sent along with certificates it issues: the leading CA and any
+// following intermediates, stopping at the first self-signed root.
+func issuerChainPEM(caCertPEM []byte) ([]byte, error) {
+	certs, err := ParseCertificates(caCertPEM)
+	if err != nil {
+		return nil, err
+	}
+	var chain []byte
+	for _, cert := range certs {
+		if isSelfSigned(cert) {
+			break
+		}
+		chain = append(chain, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})...)
+	}
+	return chain, nil
+}
+
+func isSelfSigned(cert *x509.Certificate) bool {
+	return bytes.Equal(cert.RawIssuer, cert.RawSubject) && cert.CheckSignatureFrom(cert) == nil
+}
+
+func newSerial() (*big.Int, error) {
+	return rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+}
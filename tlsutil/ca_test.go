@@ -0,0 +1,157 @@
+package tlsutil
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"testing"
+	"time"
+)
+
+// verifyNode verifies a node certificate chain against the given roots,
+// using any certificates following the leaf as intermediates.
+func verifyNode(t *testing.T, certPEM, keyPEM, rootsPEM []byte, extra ...[]byte) error {
+	t.Helper()
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots, err := CertPoolFromPEM(rootsPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	intermediates := x509.NewCertPool()
+	for _, der := range cert.Certificate[1:] {
+		c, err := x509.ParseCertificate(der)
+		if err != nil {
+			t.Fatal(err)
+		}
+		intermediates.AddCert(c)
+	}
+	for _, p := range extra {
+		certs, err := ParseCertificates(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, c := range certs {
+			intermediates.AddCert(c)
+		}
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = leaf.Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	return err
+}
+
+func TestCertPoolFromPEM(t *testing.T) {
+	oldCA, _, err := GenerateCA("test-org", 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newCA, _, err := GenerateCA("test-org", 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certs, err := ParseCertificates(BundlePEM(oldCA, newCA))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(certs) != 2 {
+		t.Fatalf("expected 2 certificates, got %d", len(certs))
+	}
+
+	if _, err := CertPoolFromPEM([]byte("not a certificate")); err == nil {
+		t.Fatal("expected error for empty bundle")
+	}
+	corrupt := append(BundlePEM(oldCA), []byte("-----BEGIN CERTIFICATE-----\nAAAA\n-----END CERTIFICATE-----\n")...)
+	if _, err := CertPoolFromPEM(corrupt); err == nil {
+		t.Fatal("expected error for corrupt certificate in bundle")
+	}
+}
+
+func TestIntermediateCA(t *testing.T) {
+	rootCert, rootKey, err := GenerateCA("root-org", 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	interCert, interKey, err := GenerateIntermediateCA(rootCert, rootKey, "intermediate-org", 12*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	nodeCert, nodeKey, err := GenerateNodeCert(interCert, interKey, "node-1", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certs, err := ParseCertificates(nodeCert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(certs) != 2 {
+		t.Fatalf("expected node certificate with intermediate chain, got %d certificates", len(certs))
+	}
+
+	// Peers only need the root to verify the node
+	if err := verifyNode(t, nodeCert, nodeKey, rootCert); err != nil {
+		t.Fatalf("verify through intermediate: %v", err)
+	}
+	if _, err := MutualTLSConfig(nodeCert, nodeKey, rootCert); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCrossSignCA(t *testing.T) {
+	oldCert, oldKey, err := GenerateCA("test-org", 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newCert, newKey, err := GenerateCA("test-org", 48*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	nodeCert, nodeKey, err := GenerateNodeCert(newCert, newKey, "node-1", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := verifyNode(t, nodeCert, nodeKey, oldCert); err == nil {
+		t.Fatal("expected successor-signed node to be untrusted by the old CA alone")
+	}
+
+	crossCert, err := CrossSignCA(oldCert, oldKey, newCert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := verifyNode(t, nodeCert, nodeKey, oldCert, crossCert); err != nil {
+		t.Fatalf("verify through cross-signed CA: %v", err)
+	}
+	if err := verifyNode(t, nodeCert, nodeKey, BundlePEM(oldCert, newCert)); err != nil {
+		t.Fatalf("verify with both CAs trusted: %v", err)
+	}
+
+	cross, err := ParseCertificates(crossCert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old, err := ParseCertificates(oldCert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cross[0].NotAfter.After(old[0].NotAfter) {
+		t.Fatal("cross-signed certificate outlives its issuer")
+	}
+
+	nodeOnly, _, err := GenerateNodeCert(oldCert, oldKey, "node-2", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := CrossSignCA(oldCert, oldKey, nodeOnly); err == nil {
+		t.Fatal("expected error cross-signing a non-CA certificate")
+	}
+}
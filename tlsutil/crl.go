@@ -0,0 +1,220 @@
+package tlsutil
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// GenerateCRL creates a PEM-encoded certificate revocation list, signed by
+// the given CA, revoking the certificates with the given serial numbers.
+// The CRL is valid for the given duration. CRL numbers are taken from the
+// current time, so a later CRL from the same CA always supersedes an
+// earlier one.
+func GenerateCRL(caCertPEM, caKeyPEM []byte, serials []*big.Int, validity time.Duration) ([]byte, error) {
+	caCert, caKey, err := parseCA(caCertPEM, caKeyPEM)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	entries := make([]x509.RevocationListEntry, len(serials))
+	for i, serial := range serials {
+		entries[i] = x509.RevocationListEntry{SerialNumber: serial, RevocationTime: now}
+	}
+	template := &x509.RevocationList{
+		RevokedCertificateEntries: entries,
+		Number:                    big.NewInt(now.UnixNano()),
+		ThisUpdate:                now.Add(-1 * time.Minute),
+		NextUpdate:                now.Add(validity),
+	}
+
+	crlDER, err := x509.CreateRevocationList(rand.Reader, template, caCert, caKey)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: crlDER}), nil
+}
+
+// ParseCRL parses a PEM-encoded CRL and verifies that it was signed by one
+// of the CAs in the given bundle.
+func ParseCRL(crlPEM, caCertPEM []byte) (*x509.RevocationList, error) {
+	block, _ := pem.Decode(crlPEM)
+	if block == nil || block.Type != "X509 CRL" {
+		return nil, errors.New("failed to decode CRL PEM")
+	}
+	crl, err := x509.ParseRevocationList(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	cas, err := ParseCertificates(caCertPEM)
+	if err != nil {
+		return nil, err
+	}
+	for _, ca := range cas {
+		if !bytes.Equal(ca.RawSubject, crl.RawIssuer) {
+			continue
+		}
+		if err := crl.CheckSignatureFrom(ca); err == nil {
+			return crl, nil
+		}
+	}
+	return nil, errors.New("CRL is not signed by a trusted CA")
+}
+
+// Revocation tracks the latest CRL from each CA and rejects handshakes
+// with peers presenting a revoked certificate. Apply installs the check
+// on a TLS config; register an OnUpdate hook to close connections that
+// were established before a certificate was revoked (see
+// Transport.CloseRevoked).
+type Revocation struct {
+	// revoked maps issuer subject to the set of revoked serial numbers
+	revoked atomic.Pointer[map[string]map[string]struct{}]
+
+	mu       sync.Mutex
+	crls     map[string]*x509.RevocationList
+	onUpdate []func()
+}
+
+// NewRevocation creates an empty Revocation that revokes nothing.
+func NewRevocation() *Revocation {
+	r := &Revocation{crls: make(map[string]*x509.RevocationList)}
+	r.revoked.Store(&map[string]map[string]struct{}{})
+	return r
+}
+
+// Update verifies a PEM-encoded CRL against the CA bundle and replaces
+// any previous CRL from the same CA. A CRL older than the one already
+// loaded is rejected, so a replayed CRL cannot un-revoke a certificate.
+func (r *Revocation) Update(crlPEM, caCertPEM []byte) error {
+	crl, err := ParseCRL(crlPEM, caCertPEM)
+	if err != nil {
+		return err
+	}
+	_, err = r.load(crl, true)
+	return err
+}
+
+// Add is like Update, but ignores a CRL that is not newer than the one
+// already loaded from the same CA instead of returning an error. It
+// reports whether the CRL was applied. This suits CRLs received from
+// peers, which may arrive repeatedly and out of order.
+func (r *Revocation) Add(crlPEM, caCertPEM []byte) (bool, error) {
+	crl, err := ParseCRL(crlPEM, caCertPEM)
+	if err != nil {
+		return false, err
+	}
+	return r.load(crl, false)
+}
+
+// CRLs returns the loaded CRLs, one per CA, PEM-encoded.
+func (r *Revocation) CRLs() [][]byte {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	crls := make([][]byte, 0, len(r.crls))
+	for _, crl := range r.crls {
+		crls = append(crls, pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: crl.Raw}))
+	}
+	return crls
+}
+
+func (r *Revocation) load(crl *x509.RevocationList, rejectOlder bool) (bool, error) {
+	r.mu.Lock()
+	issuer := string(crl.RawIssuer)
+	if prev, ok := r.crls[issuer]; ok && prev.Number != nil && crl.Number != nil {
+		switch cmp := crl.Number.Cmp(prev.Number); {
+		case cmp < 0 && rejectOlder:
+			r.mu.Unlock()
+			return false, fmt.Errorf("CRL number %s is older than loaded CRL %s", crl.Number, prev.Number)
+		case cmp <= 0:
+			r.mu.Unlock()
+			return false, nil
+		}
+	}
+	r.crls[issuer] = crl
+
+	revoked := make(map[string]map[string]struct{}, len(r.crls))
+	for issuer, crl := range r.crls {
+		serials := make(map[string]struct{}, len(crl.RevokedCertificateEntries))
+		for _, entry := range crl.RevokedCertificateEntries {
+			serials[string(entry.SerialNumber.Bytes())] = struct{}{}
+		}
+		revoked[issuer] = serials
+	}
+	r.revoked.Store(&revoked)
+	hooks := append([]func(){}, r.onUpdate...)
+	r.mu.Unlock()
+
+	for _, fn := range hooks {
+		fn()
+	}
+	return true, nil
+}
+
+// IsRevoked reports whether cert appears in the loaded CRL of its issuer.
+func (r *Revocation) IsRevoked(cert *x509.Certificate) bool {
+	serials, ok := (*r.revoked.Load())[string(cert.RawIssuer)]
+	if !ok {
+		return false
+	}
+	_, revoked := serials[string(cert.SerialNumber.Bytes())]
+	return revoked
+}
+
+// OnUpdate registers fn to be called after a CRL is loaded.
+func (r *Revocation) OnUpdate(fn func()) {
+	r.mu.Lock()
+	r.onUpdate = append(r.onUpdate, fn)
+	r.mu.Unlock()
+}
+
+// VerifyConnection rejects a handshake if any certificate presented by
+// the peer is revoked. It has the signature of tls.Config.VerifyConnection.
+func (r *Revocation) VerifyConnection(cs tls.ConnectionState) error {
+	for _, cert := range cs.PeerCertificates {
+		if r.IsRevoked(cert) {
+			return fmt.Errorf("certificate %q (serial %s) has been revoked", cert.Subject.CommonName, cert.SerialNumber)
+		}
+	}
+	return nil
+}
+
+// Apply returns a copy of conf that also checks peer certificates for
+// revocation, including on configs returned by GetConfigForClient. Any
+// existing VerifyConnection callback still runs first.
+func (r *Revocation) Apply(conf *tls.Config) *tls.Config {
+	conf = r.apply(conf)
+	if getConfig := conf.GetConfigForClient; getConfig != nil {
+		conf.GetConfigForClient = func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			c, err := getConfig(hello)
+			if err != nil || c == nil {
+				return c, err
+			}
+			return r.apply(c), nil
+		}
+	}
+	return conf
+}
+
+func (r *Revocation) apply(conf *tls.Config) *tls.Config {
+	conf = conf.Clone()
+	verify := conf.VerifyConnection
+	conf.VerifyConnection = func(cs tls.ConnectionState) error {
+		if verify != nil {
+			if err := verify(cs); err != nil {
+				return err
+			}
+		}
+		return r.VerifyConnection(cs)
+	}
+	return conf
+}
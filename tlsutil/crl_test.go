@@ -0,0 +1,151 @@
+package tlsutil
+
+import (
+	"crypto/x509"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+)
+
+func certSerial(t *testing.T, certPEM []byte) *big.Int {
+	t.Helper()
+	certs, err := ParseCertificates(certPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return certs[0].SerialNumber
+}
+
+func TestGenerateCRL(t *testing.T) {
+	caCert, caKey, err := GenerateCA("test-org", 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherCA, _, err := GenerateCA("other-org", 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	crlPEM, err := GenerateCRL(caCert, caKey, []*big.Int{big.NewInt(42)}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	crl, err := ParseCRL(crlPEM, caCert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(crl.RevokedCertificateEntries) != 1 || crl.RevokedCertificateEntries[0].SerialNumber.Int64() != 42 {
+		t.Fatalf("unexpected CRL entries: %+v", crl.RevokedCertificateEntries)
+	}
+
+	// The CRL is accepted from a bundle containing its CA, and only then
+	if _, err := ParseCRL(crlPEM, BundlePEM(otherCA, caCert)); err != nil {
+		t.Fatalf("expected CRL to verify against bundle: %v", err)
+	}
+	if _, err := ParseCRL(crlPEM, otherCA); err == nil {
+		t.Fatal("expected CRL from an untrusted CA to be rejected")
+	}
+	if _, err := ParseCRL(caCert, caCert); err == nil {
+		t.Fatal("expected non-CRL PEM to be rejected")
+	}
+}
+
+func TestRevocation(t *testing.T) {
+	caCert, caKey, err := GenerateCA("test-org", 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	localhost := []net.IP{net.IPv4(127, 0, 0, 1)}
+	goodCert, goodKey, err := GenerateNodeCertWithIPs(caCert, caKey, "good", localhost, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	badCert, badKey, err := GenerateNodeCertWithIPs(caCert, caKey, "bad", localhost, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rev := NewRevocation()
+	var updates int
+	rev.OnUpdate(func() { updates++ })
+
+	handshake := func(clientCert, clientKey []byte) error {
+		t.Helper()
+		serverConf, err := MutualTLSConfig(goodCert, goodKey, caCert)
+		if err != nil {
+			t.Fatal(err)
+		}
+		clientConf, err := MutualTLSConfig(clientCert, clientKey, caCert)
+		if err != nil {
+			t.Fatal(err)
+		}
+		clientConf.ServerName = "127.0.0.1"
+		serverConf = rev.Apply(serverConf)
+
+		return pipeHandshake(serverConf, clientConf)
+	}
+
+	if err := handshake(badCert, badKey); err != nil {
+		t.Fatalf("handshake before revocation: %v", err)
+	}
+
+	older, err := GenerateCRL(caCert, caKey, nil, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	crlPEM, err := GenerateCRL(caCert, caKey, []*big.Int{certSerial(t, badCert)}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := rev.Update(crlPEM, caCert); err != nil {
+		t.Fatal(err)
+	}
+	if updates != 1 {
+		t.Fatalf("expected 1 update notification, got %d", updates)
+	}
+
+	parse := func(certPEM []byte) *x509.Certificate {
+		t.Helper()
+		certs, err := ParseCertificates(certPEM)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return certs[0]
+	}
+	if !rev.IsRevoked(parse(badCert)) {
+		t.Fatal("expected certificate to be revoked")
+	}
+	if rev.IsRevoked(parse(goodCert)) {
+		t.Fatal("expected certificate not to be revoked")
+	}
+
+	if err := handshake(badCert, badKey); err == nil {
+		t.Fatal("expected handshake with revoked certificate to fail")
+	}
+	if err := handshake(goodCert, goodKey); err != nil {
+		t.Fatalf("handshake with valid certificate: %v", err)
+	}
+
+	// Replaying an older CRL does not un-revoke the certificate
+	if err := rev.Update(older, caCert); err == nil {
+		t.Fatal("expected older CRL to be rejected")
+	}
+	if !rev.IsRevoked(parse(badCert)) {
+		t.Fatal("expected certificate to remain revoked")
+	}
+
+	// Add ignores CRLs that are not newer instead of failing
+	if updated, err := rev.Add(older, caCert); err != nil || updated {
+		t.Fatalf("expected older CRL to be ignored, got %v, %v", updated, err)
+	}
+	if updated, err := rev.Add(crlPEM, caCert); err != nil || updated {
+		t.Fatalf("expected repeated CRL to be ignored, got %v, %v", updated, err)
+	}
+	if updates != 1 {
+		t.Fatalf("expected no notifications for ignored CRLs, got %d", updates)
+	}
+	if crls := rev.CRLs(); len(crls) != 1 {
+		t.Fatalf("expected 1 loaded CRL, got %d", len(crls))
+	}
+}
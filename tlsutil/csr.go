@@ -0,0 +1,82 @@
+package tlsutil
+
+import (
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"time"
+)
+
+// GenerateCSR creates a private key and a certificate signing request for
+// a node certificate with opts' NodeID and SANs. Validity and
+// OrganizationalUnits are chosen by the signer and are ignored. Returns
+// PEM-encoded CSR and key.
+func GenerateCSR(opts NodeCertOptions) (csrPEM, keyPEM []byte, err error) {
+	key, err := GenerateKey(opts.Key.Type)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	template := &x509.CertificateRequest{
+		Subject:     pkix.Name{CommonName: opts.NodeID},
+		DNSNames:    opts.DNSNames,
+		IPAddresses: opts.IPs,
+		URIs:        opts.URIs,
+	}
+	csrDER, err := x509.CreateCertificateRequest(rand.Reader, template, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	csrPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csrDER})
+
+	keyPEM, err = MarshalPrivateKey(key, opts.Key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return csrPEM, keyPEM, nil
+}
+
+// ParseCSR parses a PEM-encoded certificate signing request and checks
+// its signature.
+func ParseCSR(csrPEM []byte) (*x509.CertificateRequest, error) {
+	block, _ := pem.Decode(csrPEM)
+	if block == nil || block.Type != "CERTIFICATE REQUEST" {
+		return nil, errors.New("failed to decode certificate request PEM")
+	}
+	csr, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	if err := csr.CheckSignature(); err != nil {
+		return nil, err
+	}
+	return csr, nil
+}
+
+// SignCSR issues a node certificate for a CSR from GenerateCSR, signed by
+// the given CA. The Common Name and DNS, IP and URI SANs are copied from
+// the CSR, so callers should vet them first; other requested attributes
+// are ignored. As with GenerateNodeCertWithIPs, the returned PEM includes
+// the CA's chain if it is an intermediate.
+func SignCSR(caCertPEM, caKeyPEM, csrPEM []byte, validity time.Duration) (certPEM []byte, err error) {
+	csr, err := ParseCSR(csrPEM)
+	if err != nil {
+		return nil, err
+	}
+	return signCSR(caCertPEM, caKeyPEM, csr, validity, nil)
+}
+
+// signCSR signs csr with the signer-chosen organizational units ous.
+func signCSR(caCertPEM, caKeyPEM []byte, csr *x509.CertificateRequest, validity time.Duration, ous []string) ([]byte, error) {
+	return signNodeCert(caCertPEM, caKeyPEM, csr.PublicKey, NodeCertOptions{
+		NodeID:              csr.Subject.CommonName,
+		DNSNames:            csr.DNSNames,
+		IPs:                 csr.IPAddresses,
+		URIs:                csr.URIs,
+		OrganizationalUnits: ous,
+		Validity:            validity,
+	})
+}
@@ -0,0 +1,60 @@
+package tlsutil
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestSignCSR(t *testing.T) {
+	caCert, caKey, err := GenerateCA("test-org", 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	csrPEM, keyPEM, err := GenerateCSR(NodeCertOptions{
+		NodeID:              "node-1",
+		DNSNames:            []string{"node-1"},
+		IPs:                 []net.IP{net.IPv4(127, 0, 0, 1)},
+		OrganizationalUnits: []string{"admin"},
+		Key:                 KeyOptions{Type: KeyEd25519},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPEM, err := SignCSR(caCert, caKey, csrPEM, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certs, err := ParseCertificates(certPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert := certs[0]
+	if cert.Subject.CommonName != "node-1" || len(cert.DNSNames) != 1 || len(cert.IPAddresses) != 1 {
+		t.Fatalf("unexpected subject %s, SANs %v %v", cert.Subject, cert.DNSNames, cert.IPAddresses)
+	}
+	if len(cert.Subject.OrganizationalUnit) != 0 {
+		t.Fatalf("requested roles must not be issued, got %v", cert.Subject.OrganizationalUnit)
+	}
+	if time.Until(cert.NotAfter) > time.Hour {
+		t.Fatalf("unexpected expiry %s", cert.NotAfter)
+	}
+
+	// The issued certificate pairs with the CSR's key
+	conf, err := MutualTLSConfig(certPEM, keyPEM, caCert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf.ServerName = "node-1"
+	if err := pipeHandshake(conf, conf); err != nil {
+		t.Fatal(err)
+	}
+
+	// A tampered CSR fails its signature check
+	csrPEM[len(csrPEM)/2] ^= 1
+	if _, err := SignCSR(caCert, caKey, csrPEM, time.Hour); err == nil {
+		t.Fatal("expected error signing a corrupt CSR")
+	}
+}
@@ -0,0 +1,178 @@
+package tlsutil
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// FileSource names the PEM files holding a node's certificate, key and
+// trusted CA bundle.
+type FileSource struct {
+	CertFile string
+	KeyFile  string
+	CAFile   string
+}
+
+// DirSource returns a FileSource for a directory laid out like a
+// Kubernetes TLS secret: tls.crt, tls.key and ca.crt.
+func DirSource(dir string) FileSource {
+	return FileSource{
+		CertFile: filepath.Join(dir, "tls.crt"),
+		KeyFile:  filepath.Join(dir, "tls.key"),
+		CAFile:   filepath.Join(dir, "ca.crt"),
+	}
+}
+
+// FileLoader loads TLS material from files into a Reloadable and can
+// watch the files for changes. Updates are validated before they are
+// applied, so a half-written or mismatched set of files never replaces
+// working material.
+type FileLoader struct {
+	source     FileSource
+	reloadable *Reloadable
+
+	mu       sync.Mutex
+	lastSeen [sha256.Size]byte
+
+	stopCh chan struct{}
+	stop   sync.Once
+	wg     sync.WaitGroup
+}
+
+// NewFileLoader loads and validates the files named by source.
+func NewFileLoader(source FileSource) (*FileLoader, error) {
+	certPEM, keyPEM, caPEM, sum, err := source.read()
+	if err != nil {
+		return nil, err
+	}
+	if err := validateMaterial(certPEM, keyPEM, caPEM); err != nil {
+		return nil, err
+	}
+	r, err := NewReloadable(certPEM, keyPEM, caPEM)
+	if err != nil {
+		return nil, err
+	}
+	return &FileLoader{
+		source:     source,
+		reloadable: r,
+		lastSeen:   sum,
+		stopCh:     make(chan struct{}),
+	}, nil
+}
+
+// Reloadable returns the Reloadable holding the current material.
+func (l *FileLoader) Reloadable() *Reloadable {
+	return l.reloadable
+}
+
+// Config returns a TLS config serving the current material. See
+// Reloadable.Config.
+func (l *FileLoader) Config() *tls.Config {
+	return l.reloadable.Config()
+}
+
+// Reload re-reads the files and applies them if their contents changed
+// and they form a valid set. It reports whether new material was applied.
+// A set that fails validation is not retried until the files change again.
+func (l *FileLoader) Reload() (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	certPEM, keyPEM, caPEM, sum, err := l.source.read()
+	if err != nil {
+		return false, err
+	}
+	if sum == l.lastSeen {
+		return false, nil
+	}
+	l.lastSeen = sum
+
+	if err := validateMaterial(certPEM, keyPEM, caPEM); err != nil {
+		return false, fmt.Errorf("rejected updated TLS material: %w", err)
+	}
+	if err := l.reloadable.Update(certPEM, keyPEM, caPEM); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Watch polls the files every interval in the background, calling
+// onError (if non-nil) with any read or validation failure. Polling file
+// contents, rather than relying on filesystem events, also catches the
+// atomic symlink swaps used by Kubernetes secret volumes.
+func (l *FileLoader) Watch(interval time.Duration, onError func(error)) {
+	l.wg.Add(1)
+	go func() {
+		defer l.wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if _, err := l.Reload(); err != nil && onError != nil {
+					onError(err)
+				}
+			case <-l.stopCh:
+				return
+			}
+		}
+	}()
+}
+
+// Close stops watching.
+func (l *FileLoader) Close() {
+	l.stop.Do(func() { close(l.stopCh) })
+	l.wg.Wait()
+}
+
+func (s FileSource) read() (certPEM, keyPEM, caPEM []byte, sum [sha256.Size]byte, err error) {
+	if certPEM, err = os.ReadFile(s.CertFile); err != nil {
+		return
+	}
+	if keyPEM, err = os.ReadFile(s.KeyFile); err != nil {
+		return
+	}
+	if caPEM, err = os.ReadFile(s.CAFile); err != nil {
+		return
+	}
+	sum = sha256.Sum256(bytes.Join([][]byte{certPEM, keyPEM, caPEM}, []byte{0}))
+	return
+}
+
+// validateMaterial checks that the key matches the certificate and that
+// the certificate is currently valid and chains to the CA bundle.
+func validateMaterial(certPEM, keyPEM, caPEM []byte) error {
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return err
+	}
+	roots, err := CertPoolFromPEM(caPEM)
+	if err != nil {
+		return err
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return err
+	}
+	intermediates := x509.NewCertPool()
+	for _, der := range cert.Certificate[1:] {
+		c, err := x509.ParseCertificate(der)
+		if err != nil {
+			return err
+		}
+		intermediates.AddCert(c)
+	}
+	_, err = leaf.Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	return err
+}
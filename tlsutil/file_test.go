@@ -0,0 +1,111 @@
+package tlsutil
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeSecret writes a Kubernetes-style secret volume: the files live in
+// a timestamped directory referenced through a ..data symlink, which is
+// swapped atomically on update.
+func writeSecret(t *testing.T, dir, version string, certPEM, keyPEM, caPEM []byte) {
+	t.Helper()
+	versionDir := filepath.Join(dir, "..version_"+version)
+	if err := os.Mkdir(versionDir, 0o700); err != nil {
+		t.Fatal(err)
+	}
+	for name, data := range map[string][]byte{"tls.crt": certPEM, "tls.key": keyPEM, "ca.crt": caPEM} {
+		if err := os.WriteFile(filepath.Join(versionDir, name), data, 0o600); err != nil {
+			t.Fatal(err)
+		}
+		link := filepath.Join(dir, name)
+		if _, err := os.Lstat(link); os.IsNotExist(err) {
+			if err := os.Symlink(filepath.Join("..data", name), link); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	tmp := filepath.Join(dir, "..data_tmp")
+	if err := os.Symlink(filepath.Base(versionDir), tmp); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(tmp, filepath.Join(dir, "..data")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFileLoader(t *testing.T) {
+	caCert, caKey, err := GenerateCA("test-org", 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert1, key1, err := GenerateNodeCert(caCert, caKey, "node-1", 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	writeSecret(t, dir, "1", cert1, key1, caCert)
+
+	loader, err := NewFileLoader(DirSource(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer loader.Close()
+
+	errCh := make(chan error, 10)
+	loader.Watch(10*time.Millisecond, func(err error) { errCh <- err })
+
+	waitForCert := func(old []byte) bool {
+		deadline := time.Now().Add(5 * time.Second)
+		for time.Now().Before(deadline) {
+			if string(loader.Reloadable().Certificate().Certificate[0]) != string(old) {
+				return true
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		return false
+	}
+
+	// A valid update is picked up
+	initial := loader.Reloadable().Certificate().Certificate[0]
+	cert2, key2, err := GenerateNodeCert(caCert, caKey, "node-1", 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeSecret(t, dir, "2", cert2, key2, caCert)
+	if !waitForCert(initial) {
+		t.Fatal("updated certificate was not loaded")
+	}
+
+	// A certificate from an untrusted CA is rejected and the current
+	// material kept
+	current := loader.Reloadable().Certificate().Certificate[0]
+	otherCA, otherKey, err := GenerateCA("other-org", 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert3, key3, err := GenerateNodeCert(otherCA, otherKey, "node-1", 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeSecret(t, dir, "3", cert3, key3, caCert)
+	select {
+	case err := <-errCh:
+		t.Logf("rejected as expected: %v", err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected validation error")
+	}
+	if string(loader.Reloadable().Certificate().Certificate[0]) != string(current) {
+		t.Fatal("invalid material replaced the current certificate")
+	}
+
+	// Mismatched files at startup are an error
+	bad := t.TempDir()
+	writeSecret(t, bad, "1", cert1, key2, caCert)
+	if _, err := NewFileLoader(DirSource(bad)); err == nil {
+		t.Fatal("expected error for mismatched key")
+	}
+}
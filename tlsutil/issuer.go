@@ -0,0 +1,132 @@
+package tlsutil
+
+import (
+	"crypto/x509"
+	"fmt"
+	"net"
+	"path"
+	"time"
+)
+
+const defaultIssuerMaxTTL = 24 * time.Hour
+
+// IssuerPolicy restricts the certificates an Issuer signs.
+type IssuerPolicy struct {
+	// AllowedNames are path.Match patterns, such as "node-*" or
+	// "spiffe://example.org/memberlist/*". If set, the Common Name and
+	// every DNS and URI SAN requested must match one of them.
+	AllowedNames []string
+
+	// AllowedNetworks, if set, must contain every IP SAN requested.
+	AllowedNetworks []*net.IPNet
+
+	// MaxTTL caps the validity of issued certificates. Defaults to 24
+	// hours.
+	MaxTTL time.Duration
+}
+
+// Issuer is a long-running certificate authority that signs node CSRs
+// subject to a policy. It is safe for concurrent use.
+type Issuer struct {
+	caCertPEM []byte
+	caKeyPEM  []byte
+	policy    IssuerPolicy
+}
+
+// NewIssuer creates an Issuer signing with the given CA, which may be an
+// intermediate with its chain (see GenerateNodeCertWithIPs). The CA key
+// must not be encrypted.
+func NewIssuer(caCertPEM, caKeyPEM []byte, policy IssuerPolicy) (*Issuer, error) {
+	if _, _, err := parseCA(caCertPEM, caKeyPEM); err != nil {
+		return nil, err
+	}
+	for _, pattern := range policy.AllowedNames {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid name pattern %q: %w", pattern, err)
+		}
+	}
+	if policy.MaxTTL == 0 {
+		policy.MaxTTL = defaultIssuerMaxTTL
+	}
+	return &Issuer{caCertPEM: caCertPEM, caKeyPEM: caKeyPEM, policy: policy}, nil
+}
+
+// CACert returns the issuer's PEM-encoded CA certificate.
+func (i *Issuer) CACert() []byte {
+	return i.caCertPEM
+}
+
+// Sign issues a node certificate for a CSR from GenerateCSR, valid for
+// ttl or the policy's MaxTTL, whichever is shorter. A ttl of zero
+// requests MaxTTL.
+func (i *Issuer) Sign(csrPEM []byte, ttl time.Duration) ([]byte, error) {
+	return i.sign(csrPEM, ttl, nil)
+}
+
+// Renew is like Sign, but keeps the OrganizationalUnits of current, the
+// certificate being renewed, so that roles checked by authorizers such
+// as RequireRole survive renewal. The caller must have authenticated
+// current and checked that csr requests the same identity.
+func (i *Issuer) Renew(current *x509.Certificate, csrPEM []byte, ttl time.Duration) ([]byte, error) {
+	return i.sign(csrPEM, ttl, current.Subject.OrganizationalUnit)
+}
+
+func (i *Issuer) sign(csrPEM []byte, ttl time.Duration, ous []string) ([]byte, error) {
+	csr, err := ParseCSR(csrPEM)
+	if err != nil {
+		return nil, err
+	}
+	if err := i.Check(csr); err != nil {
+		return nil, err
+	}
+	if ttl <= 0 || ttl > i.policy.MaxTTL {
+		ttl = i.policy.MaxTTL
+	}
+	return signCSR(i.caCertPEM, i.caKeyPEM, csr, ttl, ous)
+}
+
+// Check reports whether the names and addresses requested by csr are
+// allowed by the issuer's policy.
+func (i *Issuer) Check(csr *x509.CertificateRequest) error {
+	if len(i.policy.AllowedNames) > 0 {
+		names := append([]string{csr.Subject.CommonName}, csr.DNSNames...)
+		for _, uri := range csr.URIs {
+			names = append(names, uri.String())
+		}
+		for _, name := range names {
+			if name == "" && len(names) > 1 {
+				// SVIDs have no Common Name
+				continue
+			}
+			if !i.allowedName(name) {
+				return fmt.Errorf("name %q not allowed by issuer policy", name)
+			}
+		}
+	}
+	if len(i.policy.AllowedNetworks) > 0 {
+		for _, ip := range csr.IPAddresses {
+			if !i.allowedIP(ip) {
+				return fmt.Errorf("IP %s not allowed by issuer policy", ip)
+			}
+		}
+	}
+	return nil
+}
+
+func (i *Issuer) allowedName(name string) bool {
+	for _, pattern := range i.policy.AllowedNames {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (i *Issuer) allowedIP(ip net.IP) bool {
+	for _, network := range i.policy.AllowedNetworks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,79 @@
+package tlsutil
+
+import (
+	"net"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestIssuer(t *testing.T) {
+	caCert, caKey, err := GenerateCA("test-org", 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, network, _ := net.ParseCIDR("10.0.0.0/8")
+	issuer, err := NewIssuer(caCert, caKey, IssuerPolicy{
+		AllowedNames:    []string{"node-*", "spiffe://example.org/memberlist/*"},
+		AllowedNetworks: []*net.IPNet{network},
+		MaxTTL:          time.Hour,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	svid, _ := NewSPIFFEID("example.org", "memberlist", "node-1")
+	other, _ := NewSPIFFEID("example.org", "admin")
+
+	tests := []struct {
+		name  string
+		opts  NodeCertOptions
+		allow bool
+	}{
+		{"allowed name", NodeCertOptions{NodeID: "node-1", DNSNames: []string{"node-1"}}, true},
+		{"allowed IP", NodeCertOptions{NodeID: "node-1", IPs: []net.IP{net.ParseIP("10.1.2.3")}}, true},
+		{"allowed SVID", NodeCertOptions{URIs: []*url.URL{svid}}, true},
+		{"disallowed CN", NodeCertOptions{NodeID: "admin"}, false},
+		{"disallowed DNS SAN", NodeCertOptions{NodeID: "node-1", DNSNames: []string{"admin"}}, false},
+		{"disallowed IP", NodeCertOptions{NodeID: "node-1", IPs: []net.IP{net.ParseIP("192.168.0.1")}}, false},
+		{"disallowed SVID", NodeCertOptions{URIs: []*url.URL{other}}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			csr, _, err := GenerateCSR(tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = issuer.Sign(csr, time.Hour)
+			if allowed := err == nil; allowed != tt.allow {
+				t.Fatalf("expected allow=%v, got %v", tt.allow, err)
+			}
+		})
+	}
+
+	// Requested TTLs are capped by the policy
+	csr, _, err := GenerateCSR(NodeCertOptions{NodeID: "node-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct{ ttl, want time.Duration }{
+		{0, time.Hour},
+		{48 * time.Hour, time.Hour},
+		{10 * time.Minute, 10 * time.Minute},
+	} {
+		certPEM, err := issuer.Sign(csr, tt.ttl)
+		if err != nil {
+			t.Fatal(err)
+		}
+		certs, err := ParseCertificates(certPEM)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := time.Until(certs[0].NotAfter); got > tt.want || got < tt.want-time.Minute {
+			t.Errorf("ttl %s: expected validity %s, got %s", tt.ttl, tt.want, got)
+		}
+	}
+
+	if _, err := NewIssuer(caCert, caKey, IssuerPolicy{AllowedNames: []string{"["}}); err == nil {
+		t.Fatal("expected error for invalid name pattern")
+	}
+}
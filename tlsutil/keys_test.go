@@ -0,0 +1,171 @@
+package tlsutil
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/tls"
+	"encoding/pem"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestKeyTypes(t *testing.T) {
+	keyTypes := []KeyType{KeyECDSAP256, KeyECDSAP384, KeyEd25519, KeyRSA2048}
+	for _, caType := range keyTypes {
+		t.Run(caType.String(), func(t *testing.T) {
+			caCert, caKey, err := GenerateCAWithOptions(CAOptions{
+				Organization: "test-org",
+				Validity:     time.Hour,
+				Key:          KeyOptions{Type: caType, PKCS8: caType == KeyRSA2048},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := ParsePrivateKey(caKey, nil); err != nil {
+				t.Fatalf("parsing CA key: %v", err)
+			}
+
+			// Each node key type can be issued by each CA key type
+			confs := make([]*tls.Config, 0, len(keyTypes))
+			for i, nodeType := range keyTypes {
+				cert, key, err := GenerateNodeCertWithOptions(caCert, caKey, NodeCertOptions{
+					NodeID:   "node",
+					IPs:      []net.IP{net.IPv4(127, 0, 0, 1)},
+					Validity: time.Hour,
+					Key:      KeyOptions{Type: nodeType, PKCS8: i%2 == 0},
+				})
+				if err != nil {
+					t.Fatalf("%s node: %v", nodeType, err)
+				}
+				conf, err := MutualTLSConfig(cert, key, caCert)
+				if err != nil {
+					t.Fatalf("%s node: %v", nodeType, err)
+				}
+				conf.ServerName = "127.0.0.1"
+				confs = append(confs, conf)
+			}
+			for i := range confs {
+				server, client := confs[i], confs[(i+1)%len(confs)]
+				if err := pipeHandshake(server, client); err != nil {
+					t.Fatalf("handshake %s server with %s client: %v", keyTypes[i], keyTypes[(i+1)%len(keyTypes)], err)
+				}
+			}
+		})
+	}
+}
+
+func TestKeyEncoding(t *testing.T) {
+	tests := []struct {
+		opts    KeyOptions
+		pemType string
+	}{
+		{KeyOptions{Type: KeyECDSAP256}, "EC PRIVATE KEY"},
+		{KeyOptions{Type: KeyECDSAP256, PKCS8: true}, "PRIVATE KEY"},
+		{KeyOptions{Type: KeyRSA2048}, "RSA PRIVATE KEY"},
+		{KeyOptions{Type: KeyEd25519}, "PRIVATE KEY"},
+	}
+	for _, tt := range tests {
+		key, err := GenerateKey(tt.opts.Type)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keyPEM, err := MarshalPrivateKey(key, tt.opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if block, _ := pem.Decode(keyPEM); block == nil || block.Type != tt.pemType {
+			t.Errorf("%s: expected %s block", tt.opts.Type, tt.pemType)
+		}
+	}
+}
+
+func TestEncryptedPrivateKey(t *testing.T) {
+	password := []byte("correct horse")
+	caCert, caKey, err := GenerateCAWithOptions(CAOptions{
+		Organization: "test-org",
+		Validity:     time.Hour,
+		Key:          KeyOptions{Type: KeyECDSAP384, Password: password},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if block, _ := pem.Decode(caKey); block.Type != "ENCRYPTED PRIVATE KEY" {
+		t.Fatalf("expected an encrypted key, got %s", block.Type)
+	}
+
+	if _, err := ParsePrivateKey(caKey, nil); err == nil {
+		t.Fatal("expected error parsing an encrypted key without a password")
+	}
+	if _, err := ParsePrivateKey(caKey, []byte("wrong")); err == nil {
+		t.Fatal("expected error with the wrong password")
+	}
+	key, err := ParsePrivateKey(caKey, password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ec, ok := key.(*ecdsa.PrivateKey); !ok || ec.Curve != elliptic.P384() {
+		t.Fatalf("expected a P-384 key, got %T", key)
+	}
+
+	// Signing needs the decrypted key
+	if _, _, err := GenerateNodeCert(caCert, caKey, "node-1", time.Hour); err == nil {
+		t.Fatal("expected error signing with an encrypted CA key")
+	}
+	decrypted, err := DecryptPrivateKeyPEM(caKey, password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := GenerateNodeCert(caCert, decrypted, "node-1", time.Hour); err != nil {
+		t.Fatalf("signing with decrypted CA key: %v", err)
+	}
+}
+
+func TestParseOpenSSLEncryptedKey(t *testing.T) {
+	// Generated with openssl pkcs8 -topk8 -v2 <cipher> [-v2prf <prf>]
+	tests := []struct {
+		file  string
+		check func(any) bool
+	}{
+		{"testdata/p384-aes256-sha256.key", func(k any) bool {
+			ec, ok := k.(*ecdsa.PrivateKey)
+			return ok && ec.Curve == elliptic.P384()
+		}},
+		{"testdata/ed25519-aes128-sha1.key", func(k any) bool {
+			_, ok := k.(ed25519.PrivateKey)
+			return ok
+		}},
+	}
+	for _, tt := range tests {
+		keyPEM, err := os.ReadFile(tt.file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		key, err := ParsePrivateKey(keyPEM, []byte("testpassword"))
+		if err != nil {
+			t.Fatalf("%s: %v", tt.file, err)
+		}
+		if !tt.check(key) {
+			t.Fatalf("%s: unexpected key type %T", tt.file, key)
+		}
+	}
+}
+
+func FuzzParsePrivateKey(f *testing.F) {
+	for _, file := range []string{"testdata/p384-aes256-sha256.key", "testdata/ed25519-aes128-sha1.key"} {
+		keyPEM, err := os.ReadFile(file)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(keyPEM)
+	}
+
+	f.Fuzz(func(t *testing.T, keyPEM []byte) {
+		key, err := ParsePrivateKey(keyPEM, []byte("testpassword"))
+		if err == nil && key == nil {
+			t.Fatal("ParsePrivateKey returned nil without error")
+		}
+	})
+}
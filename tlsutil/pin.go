@@ -0,0 +1,295 @@
+package tlsutil
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+const fingerprintPrefix = "SHA256:"
+
+// GenerateSelfSignedCert creates a self-signed node certificate, for use
+// without a CA in PinnedConfig. The node is identified by its public key
+// fingerprint rather than a signature. Returns PEM-encoded cert and key.
+func GenerateSelfSignedCert(opts NodeCertOptions) (certPEM, keyPEM []byte, err error) {
+	key, err := GenerateKey(opts.Key.Type)
+	if err != nil {
+		return nil, nil, err
+	}
+	if opts.DNSNames == nil && isDNSName(opts.NodeID) {
+		opts.DNSNames = []string{opts.NodeID}
+	}
+	template, err := nodeCertTemplate(opts)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	certDER, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		return nil, nil, err
+	}
+	certPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER})
+
+	keyPEM, err = MarshalPrivateKey(key, opts.Key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return certPEM, keyPEM, nil
+}
+
+// Fingerprint returns the SHA-256 fingerprint of a certificate's public
+// key, formatted like OpenSSH's as "SHA256:" and unpadded base64. It is
+// unchanged when a certificate is reissued for the same key.
+func Fingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return fingerprintPrefix + base64.RawStdEncoding.EncodeToString(sum[:])
+}
+
+// FingerprintPEM returns the fingerprint of the first certificate in a
+// PEM bundle.
+func FingerprintPEM(certPEM []byte) (string, error) {
+	certs, err := ParseCertificates(certPEM)
+	if err != nil {
+		return "", err
+	}
+	return Fingerprint(certs[0]), nil
+}
+
+// ValidateFingerprint checks that s is a fingerprint as returned by
+// Fingerprint.
+func ValidateFingerprint(s string) error {
+	enc, ok := strings.CutPrefix(s, fingerprintPrefix)
+	if !ok {
+		return fmt.Errorf("fingerprint %q must start with %s", s, fingerprintPrefix)
+	}
+	sum, err := base64.RawStdEncoding.DecodeString(enc)
+	if err != nil || len(sum) != sha256.Size {
+		return fmt.Errorf("invalid fingerprint %q", s)
+	}
+	return nil
+}
+
+// KnownPeers maps node names to the public key fingerprints trusted for
+// them, like SSH's known_hosts. A peer is trusted if the node name in its
+// certificate (see NodeIDFromCert) is pinned to its fingerprint. With
+// trust on first use (TOFU), an unknown name is accepted during the
+// handshake and pinned to its fingerprint by PinConnection once the
+// handshake completes. It is safe for concurrent use.
+type KnownPeers struct {
+	mu     sync.Mutex
+	byName map[string]string
+	byFP   map[string]string
+	path   string
+	tofu   bool
+}
+
+// NewKnownPeers creates a fixed set of peers, mapping node names to
+// fingerprints. Unknown peers are rejected.
+func NewKnownPeers(pins map[string]string) (*KnownPeers, error) {
+	k := &KnownPeers{byName: make(map[string]string), byFP: make(map[string]string)}
+	for name, fp := range pins {
+		if err := k.pin(name, fp); err != nil {
+			return nil, err
+		}
+	}
+	return k, nil
+}
+
+// LoadKnownPeers reads a known-peers file of "name fingerprint" lines,
+// ignoring blank lines and "#" comments. A missing file is treated as
+// empty. If tofu is set, unknown peers are trusted on first use and
+// appended to the file; otherwise they are rejected.
+func LoadKnownPeers(path string, tofu bool) (*KnownPeers, error) {
+	k := &KnownPeers{byName: make(map[string]string), byFP: make(map[string]string), path: path, tofu: tofu}
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return k, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%s:%d: expected \"name fingerprint\"", path, lineNum)
+		}
+		if err := k.pin(fields[0], fields[1]); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, lineNum, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// pin records name's fingerprint. A name has one fingerprint and a
+// fingerprint one name, so a key cannot be reused under another name.
+func (k *KnownPeers) pin(name, fp string) error {
+	if err := ValidateFingerprint(fp); err != nil {
+		return err
+	}
+	if known, ok := k.byName[name]; ok && known != fp {
+		return fmt.Errorf("node %q is already pinned to %s", name, known)
+	}
+	if known, ok := k.byFP[fp]; ok && known != name {
+		return fmt.Errorf("fingerprint %s is already pinned to node %q", fp, known)
+	}
+	k.byName[name] = fp
+	k.byFP[fp] = name
+	return nil
+}
+
+// Add pins a node name to a fingerprint, appending it to the known-peers
+// file if there is one.
+func (k *KnownPeers) Add(name, fingerprint string) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return k.add(name, fingerprint)
+}
+
+func (k *KnownPeers) add(name, fp string) error {
+	if k.byName[name] == fp {
+		return nil
+	}
+	if strings.ContainsAny(name, " \t\r\n#") || name == "" {
+		return fmt.Errorf("invalid node name %q", name)
+	}
+	if err := k.pin(name, fp); err != nil {
+		return err
+	}
+	if k.path == "" {
+		return nil
+	}
+	f, err := os.OpenFile(k.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(f, "%s %s\n", name, fp); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Fingerprint returns the fingerprint pinned for a node name.
+func (k *KnownPeers) Fingerprint(name string) (string, bool) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	fp, ok := k.byName[name]
+	return fp, ok
+}
+
+// NodeName returns the node name pinned to a fingerprint.
+func (k *KnownPeers) NodeName(fingerprint string) (string, bool) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	name, ok := k.byFP[fingerprint]
+	return name, ok
+}
+
+// Verify checks a peer certificate against the known peers and returns
+// its node name. An unknown name is accepted if TOFU is enabled, but not
+// pinned: the certificate alone does not prove that the peer holds its
+// key.
+func (k *KnownPeers) Verify(cert *x509.Certificate) (string, error) {
+	name, _, err := k.check(cert)
+	return name, err
+}
+
+// check verifies cert like Verify and also returns its fingerprint.
+// Caller must not hold k.mu.
+func (k *KnownPeers) check(cert *x509.Certificate) (name, fp string, err error) {
+	name, err = NodeIDFromCert(cert)
+	if err != nil {
+		return "", "", err
+	}
+	fp = Fingerprint(cert)
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	known, ok := k.byName[name]
+	switch {
+	case ok && known == fp:
+		return name, fp, nil
+	case ok:
+		return "", "", fmt.Errorf("fingerprint %s of node %q does not match pinned %s", fp, name, known)
+	case !k.tofu:
+		return "", "", fmt.Errorf("unknown node %q with fingerprint %s", name, fp)
+	}
+	return name, fp, nil
+}
+
+// PinConnection verifies the peer of a completed handshake and, if it is
+// unknown and TOFU is enabled, pins it and appends it to the known-peers
+// file. It must only be called once the handshake is complete, when the
+// peer has proven it holds the key of its certificate; the transport
+// calls it on every new connection when Config.KnownPeers is set.
+func (k *KnownPeers) PinConnection(state tls.ConnectionState) error {
+	if !state.HandshakeComplete {
+		return errors.New("handshake not complete")
+	}
+	if len(state.PeerCertificates) == 0 {
+		return errors.New("no peer certificates")
+	}
+	name, fp, err := k.check(state.PeerCertificates[0])
+	if err != nil {
+		return err
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return k.add(name, fp)
+}
+
+// VerifyPeerCertificate is a tls.Config.VerifyPeerCertificate callback
+// verifying the peer's leaf certificate with Verify. It never pins: it
+// runs before the peer has proven it holds the certificate's key.
+func (k *KnownPeers) VerifyPeerCertificate(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+	if len(rawCerts) == 0 {
+		return errors.New("no peer certificates")
+	}
+	cert, err := x509.ParseCertificate(rawCerts[0])
+	if err != nil {
+		return err
+	}
+	_, err = k.Verify(cert)
+	return err
+}
+
+// PinnedConfig creates a mutual TLS config for self-signed certificates
+// (see GenerateSelfSignedCert), trusting peers by fingerprint through
+// peers rather than a CA. Peer IP addresses are not checked. With TOFU,
+// peers must also be passed to PinConnection after each handshake, as
+// the transport does when it is set as Config.KnownPeers.
+func PinnedConfig(certPEM, keyPEM []byte, peers *KnownPeers) (*tls.Config, error) {
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		// Verification is done by VerifyPeerCertificate, as there is no
+		// CA to build a chain to
+		InsecureSkipVerify:    true,
+		ClientAuth:            tls.RequireAnyClientCert,
+		VerifyPeerCertificate: peers.VerifyPeerCertificate,
+		MinVersion:            tls.VersionTLS13,
+	}, nil
+}
@@ -0,0 +1,134 @@
+package tlsutil
+
+import (
+	"crypto/tls"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestKnownPeers(t *testing.T) {
+	selfSigned := func(name string) (certPEM, keyPEM []byte, fp string) {
+		t.Helper()
+		certPEM, keyPEM, err := GenerateSelfSignedCert(NodeCertOptions{NodeID: name, Validity: time.Hour})
+		if err != nil {
+			t.Fatal(err)
+		}
+		fp, err = FingerprintPEM(certPEM)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return certPEM, keyPEM, fp
+	}
+	cert1, key1, fp1 := selfSigned("node-1")
+	cert2, key2, fp2 := selfSigned("node-2")
+	if err := ValidateFingerprint(fp1); err != nil || !strings.HasPrefix(fp1, "SHA256:") {
+		t.Fatalf("unexpected fingerprint %q: %v", fp1, err)
+	}
+
+	// Pinned: only the pinned key is trusted for each name
+	pinned, err := NewKnownPeers(map[string]string{"node-1": fp1, "node-2": fp2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf1, err := PinnedConfig(cert1, key1, pinned)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf2, err := PinnedConfig(cert2, key2, pinned)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := pipeHandshake(conf1, conf2); err != nil {
+		t.Fatalf("pinned handshake: %v", err)
+	}
+	impostorCert, impostorKey, _ := selfSigned("node-2")
+	impostor, err := PinnedConfig(impostorCert, impostorKey, pinned)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := pipeHandshake(conf1, impostor); err == nil {
+		t.Fatal("expected a different key for node-2 to be rejected")
+	}
+	unknownCert, unknownKey, _ := selfSigned("node-3")
+	unknown, err := PinnedConfig(unknownCert, unknownKey, pinned)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := pipeHandshake(conf1, unknown); err == nil {
+		t.Fatal("expected an unknown node to be rejected")
+	}
+	if name, ok := pinned.NodeName(fp2); !ok || name != "node-2" {
+		t.Fatalf("expected fingerprint to map to node-2, got %q", name)
+	}
+
+	// TOFU: the first key seen for a name is pinned and persisted once
+	// its handshake completes
+	path := filepath.Join(t.TempDir(), "known_peers")
+	tofu, err := LoadKnownPeers(path, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err := PinnedConfig(cert1, key1, tofu)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A peer presenting node-2's certificate without its key fails
+	// CertificateVerify, and is not pinned
+	forged := conf2.Clone()
+	forged.Certificates = []tls.Certificate{{
+		Certificate: conf2.Certificates[0].Certificate,
+		PrivateKey:  impostor.Certificates[0].PrivateKey,
+	}}
+	if state, err := pipeHandshakeState(server, forged); err == nil {
+		t.Fatal("expected a certificate without its key to be rejected")
+	} else if err := tofu.PinConnection(state); err == nil {
+		t.Fatal("expected an incomplete handshake not to be pinned")
+	}
+	if _, ok := tofu.Fingerprint("node-2"); ok {
+		t.Fatal("failed handshake pinned node-2")
+	}
+
+	state, err := pipeHandshakeState(server, conf2)
+	if err != nil {
+		t.Fatalf("first use: %v", err)
+	}
+	if _, ok := tofu.Fingerprint("node-2"); ok {
+		t.Fatal("node-2 pinned before its handshake completed")
+	}
+	if err := tofu.PinConnection(state); err != nil {
+		t.Fatalf("pinning first use: %v", err)
+	}
+	if err := pipeHandshake(server, impostor); err == nil {
+		t.Fatal("expected a changed key to be rejected after first use")
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "node-2 "+fp2) {
+		t.Fatalf("expected node-2 in known peers file, got:\n%s", data)
+	}
+	reloaded, err := LoadKnownPeers(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fp, ok := reloaded.Fingerprint("node-2"); !ok || fp != fp2 {
+		t.Fatalf("expected reloaded pin for node-2, got %q", fp)
+	}
+
+	// A key cannot be pinned under two names
+	if err := reloaded.Add("node-9", fp2); err == nil {
+		t.Fatal("expected error pinning a fingerprint to a second name")
+	}
+	if err := os.WriteFile(path, []byte("node-1 not-a-fingerprint\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadKnownPeers(path, false); err == nil {
+		t.Fatal("expected error for an invalid known peers file")
+	}
+}
@@ -0,0 +1,125 @@
+package tlsutil
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"sync"
+	"sync/atomic"
+)
+
+// Reloadable holds a node certificate and CA pool that can be replaced at
+// runtime. Configs returned by Config serve the current certificate on
+// every handshake, so certificate rotation takes effect immediately. The
+// CA pool is captured when Config is called; register an OnReload hook to
+// pass a fresh config to Transport.ReloadTLS when the trust bundle changes.
+type Reloadable struct {
+	cert atomic.Pointer[tls.Certificate]
+	pool atomic.Pointer[x509.CertPool]
+
+	mu       sync.Mutex
+	onReload []func(*tls.Config)
+}
+
+// NewReloadable creates a Reloadable from PEM-encoded node certificate,
+// key and CA certificate.
+func NewReloadable(certPEM, keyPEM, caCertPEM []byte) (*Reloadable, error) {
+	r := &Reloadable{}
+	if err := r.Update(certPEM, keyPEM, caCertPEM); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Update replaces the certificate and CA pool. All inputs are parsed
+// before anything is swapped, so a failed update leaves the previous
+// material in place.
+func (r *Reloadable) Update(certPEM, keyPEM, caCertPEM []byte) error {
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return err
+	}
+	pool, err := CertPoolFromPEM(caCertPEM)
+	if err != nil {
+		return err
+	}
+
+	r.cert.Store(&cert)
+	r.pool.Store(pool)
+	r.notify()
+	return nil
+}
+
+// SetCertificate replaces the node certificate. No reload is needed, so
+// OnReload hooks are not called.
+func (r *Reloadable) SetCertificate(cert tls.Certificate) {
+	r.cert.Store(&cert)
+}
+
+// SetCAPool replaces the trusted CA pool and calls the OnReload hooks.
+func (r *Reloadable) SetCAPool(pool *x509.CertPool) {
+	r.pool.Store(pool)
+	r.notify()
+}
+
+// Certificate returns the current node certificate.
+func (r *Reloadable) Certificate() *tls.Certificate {
+	return r.cert.Load()
+}
+
+// CAPool returns the current trusted CA pool.
+func (r *Reloadable) CAPool() *x509.CertPool {
+	return r.pool.Load()
+}
+
+// OnReload registers fn to be called with a fresh Config whenever the CA
+// pool changes.
+func (r *Reloadable) OnReload(fn func(*tls.Config)) {
+	r.mu.Lock()
+	r.onReload = append(r.onReload, fn)
+	r.mu.Unlock()
+}
+
+func (r *Reloadable) notify() {
+	r.mu.Lock()
+	hooks := append([]func(*tls.Config){}, r.onReload...)
+	r.mu.Unlock()
+	for _, fn := range hooks {
+		fn(r.Config())
+	}
+}
+
+// Config returns a mutual TLS config that serves the current certificate
+// and verifies peers against the current CA pool. Inbound handshakes
+// always see the latest CA pool; outbound handshakes use the pool as of
+// this call.
+func (r *Reloadable) Config() *tls.Config {
+	getCert := func() (*tls.Certificate, error) {
+		cert := r.cert.Load()
+		if cert == nil {
+			return nil, errors.New("no certificate loaded")
+		}
+		return cert, nil
+	}
+
+	pool := r.pool.Load()
+	conf := &tls.Config{
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return getCert()
+		},
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return getCert()
+		},
+		RootCAs:    pool,
+		ClientCAs:  pool,
+		ClientAuth: tls.RequireAndVerifyClientCert,
+		MinVersion: tls.VersionTLS13,
+	}
+	server := conf.Clone()
+	conf.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		server := server.Clone()
+		server.ClientCAs = r.pool.Load()
+		return server, nil
+	}
+	return conf
+}
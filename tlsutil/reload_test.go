@@ -0,0 +1,79 @@
+package tlsutil
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"testing"
+	"time"
+)
+
+func TestReloadable(t *testing.T) {
+	caCert, caKey, err := GenerateCA("test-org", 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert1, key1, err := GenerateNodeCert(caCert, caKey, "node-1", 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert2, key2, err := GenerateNodeCert(caCert, caKey, "node-1", 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := NewReloadable(cert1, key1, caCert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf := r.Config()
+
+	leaf := func() *x509.Certificate {
+		t.Helper()
+		cert, err := conf.GetCertificate(&tls.ClientHelloInfo{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		parsed, err := x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return parsed
+	}
+	before := leaf().SerialNumber
+
+	var reloaded int
+	r.OnReload(func(*tls.Config) { reloaded++ })
+
+	if err := r.Update(cert2, key2, caCert); err != nil {
+		t.Fatal(err)
+	}
+	if leaf().SerialNumber.Cmp(before) == 0 {
+		t.Fatal("expected existing config to serve the rotated certificate")
+	}
+	if reloaded != 1 {
+		t.Fatalf("expected 1 reload notification, got %d", reloaded)
+	}
+
+	// A bad update leaves the current material in place
+	current := r.Certificate()
+	if err := r.Update(cert1, key2, caCert); err == nil {
+		t.Fatal("expected error for mismatched key")
+	}
+	if r.Certificate() != current {
+		t.Fatal("failed update replaced the certificate")
+	}
+
+	// Inbound handshakes see CA pool changes without a new Config
+	pool := x509.NewCertPool()
+	r.SetCAPool(pool)
+	server, err := conf.GetConfigForClient(&tls.ClientHelloInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if server.ClientCAs != pool {
+		t.Fatal("expected server config to use the new CA pool")
+	}
+	if reloaded != 2 {
+		t.Fatalf("expected 2 reload notifications, got %d", reloaded)
+	}
+}
@@ -0,0 +1,141 @@
+package tlsutil
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/url"
+	"path"
+	"slices"
+	"strings"
+)
+
+// NewSPIFFEID builds a SPIFFE ID (spiffe://trust-domain/seg/...) from a
+// trust domain and path segments.
+func NewSPIFFEID(trustDomain string, segments ...string) (*url.URL, error) {
+	id := &url.URL{Scheme: "spiffe", Host: trustDomain, Path: "/" + strings.Join(segments, "/")}
+	if err := validateSPIFFEID(id); err != nil {
+		return nil, err
+	}
+	return id, nil
+}
+
+// ParseSPIFFEID parses and validates a SPIFFE ID.
+func ParseSPIFFEID(s string) (*url.URL, error) {
+	id, err := url.Parse(s)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateSPIFFEID(id); err != nil {
+		return nil, err
+	}
+	return id, nil
+}
+
+func validateSPIFFEID(id *url.URL) error {
+	switch {
+	case id.Scheme != "spiffe":
+		return fmt.Errorf("%q is not a SPIFFE ID", id)
+	case id.Host == "" || id.Host != strings.ToLower(id.Host) || id.Port() != "":
+		return fmt.Errorf("SPIFFE ID %q has an invalid trust domain", id)
+	case id.User != nil || id.RawQuery != "" || id.Fragment != "":
+		return fmt.Errorf("SPIFFE ID %q must not have user info, query or fragment", id)
+	case id.Path == "" || id.Path == "/" || strings.HasSuffix(id.Path, "/") || path.Clean(id.Path) != id.Path:
+		return fmt.Errorf("SPIFFE ID %q has an invalid path", id)
+	}
+	return nil
+}
+
+// SPIFFEID returns the SPIFFE ID of an X.509 SVID. An SVID carries exactly
+// one URI SAN, which must be a SPIFFE ID.
+func SPIFFEID(cert *x509.Certificate) (*url.URL, error) {
+	if len(cert.URIs) != 1 {
+		return nil, fmt.Errorf("certificate has %d URI SANs, an SVID has exactly one", len(cert.URIs))
+	}
+	id := cert.URIs[0]
+	if err := validateSPIFFEID(id); err != nil {
+		return nil, err
+	}
+	return id, nil
+}
+
+// SPIFFENodeName maps a SPIFFE ID to a memberlist node name by removing a
+// path prefix, so spiffe://example.org/memberlist/node-1 with prefix
+// "/memberlist" maps to "node-1". It fails if the ID is not under prefix.
+func SPIFFENodeName(id *url.URL, pathPrefix string) (string, error) {
+	prefix := strings.TrimSuffix(pathPrefix, "/") + "/"
+	name, ok := strings.CutPrefix(id.Path, prefix)
+	if !ok || name == "" {
+		return "", fmt.Errorf("SPIFFE ID %q is not under %q", id, pathPrefix)
+	}
+	return name, nil
+}
+
+// SPIFFEConfig creates a mutual TLS config for X.509 SVIDs. Peers are
+// verified against the trust bundle and must present a SPIFFE ID in one
+// of the given trust domains. As SVIDs identify workloads rather than
+// hosts, peer IP addresses are not checked. Restrict individual IDs
+// further with the transport's Authorizer.
+func SPIFFEConfig(svidPEM, keyPEM, bundlePEM []byte, trustDomains ...string) (*tls.Config, error) {
+	if len(trustDomains) == 0 {
+		return nil, errors.New("at least one trust domain is required")
+	}
+	cert, err := tls.X509KeyPair(svidPEM, keyPEM)
+	if err != nil {
+		return nil, err
+	}
+	bundle, err := CertPoolFromPEM(bundlePEM)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		// Verification is done by VerifyPeerCertificate, which checks the
+		// chain without the host name check
+		InsecureSkipVerify:    true,
+		ClientAuth:            tls.RequireAnyClientCert,
+		VerifyPeerCertificate: VerifySPIFFE(bundle, trustDomains...),
+		MinVersion:            tls.VersionTLS13,
+	}, nil
+}
+
+// VerifySPIFFE returns a tls.Config.VerifyPeerCertificate callback that
+// verifies the peer's SVID chain against the bundle and requires its
+// SPIFFE ID to be in one of the trust domains.
+func VerifySPIFFE(bundle *x509.CertPool, trustDomains ...string) func([][]byte, [][]*x509.Certificate) error {
+	return func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+		if len(rawCerts) == 0 {
+			return errors.New("no peer certificates")
+		}
+		certs := make([]*x509.Certificate, len(rawCerts))
+		for i, raw := range rawCerts {
+			cert, err := x509.ParseCertificate(raw)
+			if err != nil {
+				return err
+			}
+			certs[i] = cert
+		}
+		intermediates := x509.NewCertPool()
+		for _, cert := range certs[1:] {
+			intermediates.AddCert(cert)
+		}
+		if _, err := certs[0].Verify(x509.VerifyOptions{
+			Roots:         bundle,
+			Intermediates: intermediates,
+			KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+		}); err != nil {
+			return err
+		}
+
+		id, err := SPIFFEID(certs[0])
+		if err != nil {
+			return err
+		}
+		if !slices.Contains(trustDomains, id.Host) {
+			return fmt.Errorf("SPIFFE ID %q is not in a trusted domain", id)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,114 @@
+package tlsutil
+
+import (
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestSPIFFEID(t *testing.T) {
+	id, err := NewSPIFFEID("example.org", "memberlist", "node-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id.String() != "spiffe://example.org/memberlist/node-1" {
+		t.Fatalf("unexpected SPIFFE ID %s", id)
+	}
+	name, err := SPIFFENodeName(id, "/memberlist")
+	if err != nil || name != "node-1" {
+		t.Fatalf("expected node-1, got %q, %v", name, err)
+	}
+	if _, err := SPIFFENodeName(id, "/other"); err == nil {
+		t.Fatal("expected error for ID outside prefix")
+	}
+
+	for _, bad := range []string{
+		"https://example.org/node",
+		"spiffe://Example.org/node",
+		"spiffe://example.org:8443/node",
+		"spiffe://example.org",
+		"spiffe://example.org/node/",
+		"spiffe://example.org/a/../b",
+		"spiffe://example.org/node?x=1",
+	} {
+		if _, err := ParseSPIFFEID(bad); err == nil {
+			t.Errorf("expected %q to be rejected", bad)
+		}
+	}
+}
+
+func TestSPIFFEConfig(t *testing.T) {
+	caCert, caKey, err := GenerateCA("example.org", 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	svid := func(id string) (certPEM, keyPEM []byte) {
+		t.Helper()
+		uri, err := url.Parse(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		certPEM, keyPEM, err = GenerateNodeCertWithOptions(caCert, caKey, NodeCertOptions{
+			URIs:     []*url.URL{uri},
+			Validity: time.Hour,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return certPEM, keyPEM
+	}
+
+	serverCert, serverKey := svid("spiffe://example.org/memberlist/node-1")
+	serverConf, err := SPIFFEConfig(serverCert, serverKey, caCert, "example.org")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certs, err := ParseCertificates(serverCert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if nodeID, err := NodeIDFromCert(certs[0]); err != nil || nodeID != "spiffe://example.org/memberlist/node-1" {
+		t.Fatalf("unexpected node ID %q, %v", nodeID, err)
+	}
+
+	tests := []struct {
+		name  string
+		id    string
+		allow bool
+	}{
+		{"same trust domain", "spiffe://example.org/memberlist/node-2", true},
+		{"other trust domain", "spiffe://other.org/memberlist/node-2", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cert, key := svid(tt.id)
+			clientConf, err := SPIFFEConfig(cert, key, caCert, "example.org")
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = pipeHandshake(serverConf, clientConf)
+			if allowed := err == nil; allowed != tt.allow {
+				t.Fatalf("expected allow=%v, got %v", tt.allow, err)
+			}
+		})
+	}
+
+	// A certificate without a SPIFFE ID is rejected even if CA-signed
+	cert, key, err := GenerateNodeCert(caCert, caKey, "node-3", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientConf, err := MutualTLSConfig(cert, key, caCert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientConf.InsecureSkipVerify = true
+	if err := pipeHandshake(serverConf, clientConf); err == nil {
+		t.Fatal("expected certificate without a SPIFFE ID to be rejected")
+	}
+
+	if _, err := SPIFFEConfig(serverCert, serverKey, caCert); err == nil {
+		t.Fatal("expected error without trust domains")
+	}
+}
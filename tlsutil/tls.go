@@ -1,16 +1,17 @@
 package tlsutil
 
 import (
-	"crypto/ecdsa"
-	"crypto/elliptic"
+	"crypto"
 	"crypto/rand"
 	"crypto/tls"
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"encoding/pem"
 	"errors"
-	"math/big"
+	"fmt"
 	"net"
+	"net/url"
+	"strings"
 	"time"
 
 	"github.com/quic-go/quic-go"
@@ -19,12 +20,29 @@ import (
 // GenerateCA creates a self-signed CA certificate and private key suitable
 // for signing node certificates. Returns PEM-encoded cert and key.
 func GenerateCA(org string, validity time.Duration) (certPEM, keyPEM []byte, err error) {
-	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	return GenerateCAWithOptions(CAOptions{Organization: org, Validity: validity})
+}
+
+// CAOptions describes a CA certificate.
+type CAOptions struct {
+	Organization string
+	Validity     time.Duration
+
+	// Key selects the CA key's algorithm and encoding. An encrypted CA
+	// key must be decrypted with DecryptPrivateKeyPEM before it is used
+	// to sign.
+	Key KeyOptions
+}
+
+// GenerateCAWithOptions creates a self-signed CA certificate and private
+// key. See GenerateCA.
+func GenerateCAWithOptions(opts CAOptions) (certPEM, keyPEM []byte, err error) {
+	key, err := GenerateKey(opts.Key.Type)
 	if err != nil {
 		return nil, nil, err
 	}
 
-	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	serial, err := newSerial()
 	if err != nil {
 		return nil, nil, err
 	}
@@ -32,27 +50,26 @@ func GenerateCA(org string, validity time.Duration) (certPEM, keyPEM []byte, err
 	template := &x509.Certificate{
 		SerialNumber: serial,
 		Subject: pkix.Name{
-			Organization: []string{org},
+			Organization: []string{opts.Organization},
 		},
 		NotBefore:             time.Now().Add(-1 * time.Minute),
-		NotAfter:              time.Now().Add(validity),
+		NotAfter:              time.Now().Add(opts.Validity),
 		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
 		BasicConstraintsValid: true,
 		IsCA:                  true,
 	}
 
-	certDER, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	certDER, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
 	if err != nil {
 		return nil, nil, err
 	}
 
 	certPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER})
 
-	keyDER, err := x509.MarshalECPrivateKey(key)
+	keyPEM, err = MarshalPrivateKey(key, opts.Key)
 	if err != nil {
 		return nil, nil, err
 	}
-	keyPEM = pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
 
 	return certPEM, keyPEM, nil
 }
@@ -64,63 +81,134 @@ func GenerateNodeCert(caCertPEM, caKeyPEM []byte, nodeID string, validity time.D
 }
 
 // GenerateNodeCertWithIPs creates a node certificate signed by the given CA
-// with the specified IP SANs. The nodeID is set as the Common Name.
+// with the specified IP SANs. The nodeID is set as the Common Name and,
+// if it is a valid DNS name, as a DNS SAN so peers can verify the node by
+// name. If the CA is an intermediate, caCertPEM should hold its chain up
+// to the root and the returned certificate PEM will include that chain.
 func GenerateNodeCertWithIPs(caCertPEM, caKeyPEM []byte, nodeID string, ips []net.IP, validity time.Duration) (certPEM, keyPEM []byte, err error) {
-	caCert, caKey, err := parseCA(caCertPEM, caKeyPEM)
+	var dnsNames []string
+	if isDNSName(nodeID) {
+		dnsNames = []string{nodeID}
+	}
+	return GenerateNodeCertWithOptions(caCertPEM, caKeyPEM, NodeCertOptions{
+		NodeID:   nodeID,
+		DNSNames: dnsNames,
+		IPs:      ips,
+		Validity: validity,
+	})
+}
+
+// NodeCertOptions describes a node certificate.
+type NodeCertOptions struct {
+	// NodeID is set as the Common Name. It may be left empty for SVIDs,
+	// which are identified by their SPIFFE ID.
+	NodeID string
+
+	// DNSNames are added as DNS SANs. Including the node name lets peers
+	// verify the node by name rather than by IP address.
+	DNSNames []string
+
+	// IPs are added as IP SANs.
+	IPs []net.IP
+
+	// URIs are added as URI SANs. An X.509 SVID has exactly one, its
+	// SPIFFE ID (see NewSPIFFEID).
+	URIs []*url.URL
+
+	// OrganizationalUnits are set in the subject, and can be used as
+	// roles by an authorization policy.
+	OrganizationalUnits []string
+
+	Validity time.Duration
+
+	// Key selects the node key's algorithm and encoding, independently of
+	// the CA's. Encrypted node keys must be decrypted with
+	// DecryptPrivateKeyPEM before use in a TLS config.
+	Key KeyOptions
+}
+
+// GenerateNodeCertWithOptions creates a node certificate signed by the
+// given CA. See GenerateNodeCertWithIPs.
+func GenerateNodeCertWithOptions(caCertPEM, caKeyPEM []byte, opts NodeCertOptions) (certPEM, keyPEM []byte, err error) {
+	key, err := GenerateKey(opts.Key.Type)
 	if err != nil {
 		return nil, nil, err
 	}
 
-	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	certPEM, err = signNodeCert(caCertPEM, caKeyPEM, key.Public(), opts)
 	if err != nil {
 		return nil, nil, err
 	}
 
-	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	keyPEM, err = MarshalPrivateKey(key, opts.Key)
 	if err != nil {
 		return nil, nil, err
 	}
 
-	template := &x509.Certificate{
-		SerialNumber: serial,
-		Subject: pkix.Name{
-			CommonName: nodeID,
-		},
-		NotBefore:             time.Now().Add(-1 * time.Minute),
-		NotAfter:              time.Now().Add(validity),
-		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
-		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
-		BasicConstraintsValid: true,
-		IPAddresses:           ips,
+	return certPEM, keyPEM, nil
+}
+
+// signNodeCert issues a node certificate for pub, followed by the CA's
+// issuer chain. opts.Key is not used.
+func signNodeCert(caCertPEM, caKeyPEM []byte, pub crypto.PublicKey, opts NodeCertOptions) ([]byte, error) {
+	caCert, caKey, err := parseCA(caCertPEM, caKeyPEM)
+	if err != nil {
+		return nil, err
 	}
 
-	certDER, err := x509.CreateCertificate(rand.Reader, template, caCert, &key.PublicKey, caKey)
+	template, err := nodeCertTemplate(opts)
 	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
 
-	certPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER})
+	certDER, err := x509.CreateCertificate(rand.Reader, template, caCert, pub, caKey)
+	if err != nil {
+		return nil, err
+	}
 
-	keyDER, err := x509.MarshalECPrivateKey(key)
+	chain, err := issuerChainPEM(caCertPEM)
 	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
-	keyPEM = pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	return append(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER}), chain...), nil
+}
 
-	return certPEM, keyPEM, nil
+func nodeCertTemplate(opts NodeCertOptions) (*x509.Certificate, error) {
+	serial, err := newSerial()
+	if err != nil {
+		return nil, err
+	}
+
+	return &x509.Certificate{
+		SerialNumber: serial,
+		Subject: pkix.Name{
+			CommonName:         opts.NodeID,
+			OrganizationalUnit: opts.OrganizationalUnits,
+		},
+		NotBefore:             time.Now().Add(-1 * time.Minute),
+		NotAfter:              time.Now().Add(opts.Validity),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		DNSNames:              opts.DNSNames,
+		IPAddresses:           opts.IPs,
+		URIs:                  opts.URIs,
+	}, nil
 }
 
 // MutualTLSConfig creates a tls.Config for mutual TLS authentication
-// using the given node certificate and CA certificate pool.
+// using the given node certificate and CA certificate pool. certPEM may
+// include intermediate certificates after the node certificate, and
+// caCertPEM may be a bundle of several trusted CAs.
 func MutualTLSConfig(certPEM, keyPEM, caCertPEM []byte) (*tls.Config, error) {
 	cert, err := tls.X509KeyPair(certPEM, keyPEM)
 	if err != nil {
 		return nil, err
 	}
 
-	caPool := x509.NewCertPool()
-	if !caPool.AppendCertsFromPEM(caCertPEM) {
-		return nil, errors.New("failed to parse CA certificate")
+	caPool, err := CertPoolFromPEM(caCertPEM)
+	if err != nil {
+		return nil, err
 	}
 
 	return &tls.Config{
@@ -132,22 +220,52 @@ func MutualTLSConfig(certPEM, keyPEM, caCertPEM []byte) (*tls.Config, error) {
 	}, nil
 }
 
-// NodeIDFromConn extracts the peer's node ID (Common Name) from an
-// established QUIC connection's TLS state.
+// NodeIDFromConn extracts the peer's node ID from an established QUIC
+// connection's TLS state. The ID is the peer certificate's SPIFFE ID if it
+// has one, and its Common Name otherwise.
 func NodeIDFromConn(conn *quic.Conn) (string, error) {
 	state := conn.ConnectionState()
 	certs := state.TLS.PeerCertificates
 	if len(certs) == 0 {
 		return "", errors.New("no peer certificates")
 	}
-	cn := certs[0].Subject.CommonName
+	return NodeIDFromCert(certs[0])
+}
+
+// NodeIDFromCert returns the node ID of a certificate. See NodeIDFromConn.
+func NodeIDFromCert(cert *x509.Certificate) (string, error) {
+	if id, err := SPIFFEID(cert); err == nil {
+		return id.String(), nil
+	}
+	cn := cert.Subject.CommonName
 	if cn == "" {
 		return "", errors.New("peer certificate has no Common Name")
 	}
 	return cn, nil
 }
 
-func parseCA(caCertPEM, caKeyPEM []byte) (*x509.Certificate, *ecdsa.PrivateKey, error) {
+// isDNSName reports whether name can be used as a DNS SAN: dot-separated
+// labels of letters, digits, hyphens and underscores.
+func isDNSName(name string) bool {
+	if name == "" || len(name) > 253 || net.ParseIP(name) != nil {
+		return false
+	}
+	for _, label := range strings.Split(name, ".") {
+		if label == "" || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for _, c := range label {
+			switch {
+			case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '-', c == '_':
+			default:
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func parseCA(caCertPEM, caKeyPEM []byte) (*x509.Certificate, crypto.Signer, error) {
 	block, _ := pem.Decode(caCertPEM)
 	if block == nil {
 		return nil, nil, errors.New("failed to decode CA certificate PEM")
@@ -157,13 +275,13 @@ func parseCA(caCertPEM, caKeyPEM []byte) (*x509.Certificate, *ecdsa.PrivateKey,
 		return nil, nil, err
 	}
 
-	keyBlock, _ := pem.Decode(caKeyPEM)
-	if keyBlock == nil {
-		return nil, nil, errors.New("failed to decode CA key PEM")
-	}
-	caKey, err := x509.ParseECPrivateKey(keyBlock.Bytes)
+	caKey, err := ParsePrivateKey(caKeyPEM, nil)
 	if err != nil {
-		return nil, nil, err
+		return nil, nil, fmt.Errorf("failed to parse CA key: %w", err)
+	}
+	pub, ok := caKey.Public().(interface{ Equal(crypto.PublicKey) bool })
+	if !ok || !pub.Equal(caCert.PublicKey) {
+		return nil, nil, errors.New("CA key does not match CA certificate")
 	}
 
 	return caCert, caKey, nil
@@ -3,14 +3,19 @@ package memberlistquic
 import (
 	"context"
 	"crypto/tls"
+	"crypto/x509"
 	"fmt"
 	"log"
 	"net"
+	"slices"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	metrics "github.com/hashicorp/go-metrics/compat"
 	"github.com/hashicorp/memberlist"
 	"github.com/quic-go/quic-go"
+	"github.com/wjordan/memberlist-quic/tlsutil"
 )
 
 const (
@@ -21,6 +26,8 @@ const (
 	defaultPacketQueueSize = 256
 	defaultStreamQueueSize = 16
 	defaultSweepInterval   = 30 * time.Second
+	defaultCertExpiryWarn  = 7 * 24 * time.Hour
+	defaultCertCheck       = time.Minute
 )
 
 // Config configures the QUIC transport.
@@ -28,8 +35,43 @@ type Config struct {
 	BindAddr string
 	BindPort int
 
+	// ClusterLabel, if set, isolates this cluster from others sharing its
+	// CA. It is negotiated during the TLS handshake (as an ALPN protocol
+	// suffix), so peers with a different label are refused before any
+	// memberlist traffic is exchanged. Typically set to the same value
+	// as memberlist's Config.Label.
+	ClusterLabel string
+
+	// PacketConn, if set, is used instead of binding a UDP socket from
+	// BindAddr/BindPort, which must then be left empty. By default the
+	// caller retains ownership and must close it after Shutdown; set
+	// OwnPacketConn to have the transport close it instead.
+	PacketConn    net.PacketConn
+	OwnPacketConn bool
+
+	// TLS is the initial TLS configuration. It is cloned, and may be
+	// replaced at runtime with Transport.ReloadTLS. Certificates can also
+	// be rotated without a reload by setting GetCertificate and
+	// GetClientCertificate.
 	TLS *tls.Config
 
+	// ServerName, if set, maps a peer's memberlist address to the name
+	// its certificate is verified against when dialing, instead of its IP
+	// address. This lets nodes change IP addresses without reissuing
+	// certificates. See NodeNameServerName. If it returns an empty name,
+	// such as for a join by address alone, the IP address is verified.
+	ServerName func(addr memberlist.Address) string
+
+	// Authorizer, if set, decides whether an authenticated peer may
+	// connect. Rejected connections are closed with CloseCodeUnauthorized.
+	Authorizer Authorizer
+
+	// KnownPeers, if set, pins peers trusted on first use once their
+	// handshake completes. Set it to the KnownPeers of a TOFU
+	// tlsutil.PinnedConfig; without it, unknown peers are accepted but
+	// never pinned.
+	KnownPeers *tlsutil.KnownPeers
+
 	Logger *log.Logger
 
 	MaxIdleTimeout  time.Duration
@@ -40,6 +82,39 @@ type Config struct {
 
 	MaxConnectionAge  time.Duration
 	PoolSweepInterval time.Duration
+
+	// CertExpiryWarning is how long before expiry the local or a peer's
+	// certificate chain is logged and counted as expiring.
+	// CertCheckInterval is how often certificates are checked and their
+	// remaining lifetime emitted as metrics; negative disables checks.
+	CertExpiryWarning time.Duration
+	CertCheckInterval time.Duration
+
+	// CertCheckCallbacks lets those checks find a local certificate that
+	// is not in the TLS config's Certificates by calling its
+	// GetCertificate or GetClientCertificate with an empty
+	// ClientHelloInfo or CertificateRequestInfo. Callbacks may depend on
+	// the handshake, so by default only Certificates are checked, and a
+	// local certificate served by GetCertificate or GetConfigForClient
+	// is not monitored.
+	CertCheckCallbacks bool
+
+	// MetricLabels are applied to all metrics emitted by the transport.
+	MetricLabels []metrics.Label
+
+	// Bootstrap, if set, lets this node issue certificates to new nodes
+	// presenting a join token (see Transport.Bootstrap).
+	Bootstrap *BootstrapConfig
+
+	// Issuer, if set, signs certificate renewals requested by connected
+	// peers, and this node's own renewals.
+	Issuer *tlsutil.Issuer
+
+	// Renewal, if set, renews the local certificate before it expires
+	// (see Transport.Renew). It cannot be used with a TLS config whose
+	// GetConfigForClient serves the certificate, such as
+	// tlsutil.Reloadable's.
+	Renewal *RenewalConfig
 }
 
 // Transport implements memberlist.Transport and memberlist.NodeAwareTransport
@@ -48,13 +123,40 @@ type Transport struct {
 	config     Config
 	logger     *log.Logger
 	transport  *quic.Transport
+	packetConn net.PacketConn
+	ownsConn   bool
 	listener   *quic.Listener
+	tlsConfig  atomic.Pointer[tls.Config]
+	tlsMu      sync.Mutex // serializes TLS config updates
 	pool       *ConnPool
+	channels   map[uint8]*VirtualTransport
+	channelsMu sync.Mutex
+	routes     map[string]*alpnRoute
+	routesMu   sync.RWMutex
 	packetCh   chan *memberlist.Packet
 	streamCh   chan net.Conn
 	shutdownCh chan struct{}
 	shutdown   sync.Once
 	wg         sync.WaitGroup
+
+	// Handlers for named stream protocols, such as certificate renewal
+	// and those registered with Listen, and for broadcast datagrams
+	protocols   map[string]protocolHandler
+	protocolsMu sync.RWMutex
+	broadcaster atomic.Pointer[Broadcaster]
+
+	// Connections carrying those protocols, dialed with protoTLSConfig
+	protoPool      *ConnPool
+	protoTLSConfig atomic.Pointer[tls.Config]
+
+	// Signs bootstrap certificates with Config.Bootstrap's CA and policy
+	bootstrapIssuer *tlsutil.Issuer
+
+	// ALPN protocol IDs for memberlist, protocol and bootstrap
+	// connections, which carry the cluster label
+	alpn          string
+	protoALPN     string
+	bootstrapALPN string
 }
 
 var _ memberlist.NodeAwareTransport = (*Transport)(nil)
@@ -64,6 +166,48 @@ func New(config Config) (*Transport, error) {
 	if config.TLS == nil {
 		return nil, fmt.Errorf("TLS config is required")
 	}
+	if config.PacketConn != nil && (config.BindAddr != "" || config.BindPort != 0) {
+		return nil, fmt.Errorf("BindAddr and BindPort must be empty when PacketConn is set")
+	}
+	if config.PacketConn == nil && config.OwnPacketConn {
+		return nil, fmt.Errorf("OwnPacketConn requires PacketConn")
+	}
+	if err := validateClusterLabel(config.ClusterLabel); err != nil {
+		return nil, err
+	}
+	var bootstrapIssuer *tlsutil.Issuer
+	if bc := config.Bootstrap; bc != nil {
+		if bc.Token == "" || len(bc.CACert) == 0 || len(bc.CAKey) == 0 {
+			return nil, fmt.Errorf("Bootstrap requires Token, CACert and CAKey")
+		}
+		if bc.Authorize == nil && (bc.Policy == nil || len(bc.Policy.AllowedNames) == 0) {
+			return nil, fmt.Errorf("Bootstrap requires Authorize or a Policy with AllowedNames, or any token holder could claim any node's identity")
+		}
+		bc := *bc
+		if bc.Validity == 0 {
+			bc.Validity = defaultBootstrapValidity
+		}
+		var policy tlsutil.IssuerPolicy
+		if bc.Policy != nil {
+			policy = *bc.Policy
+		}
+		policy.MaxTTL = bc.Validity
+		var err error
+		if bootstrapIssuer, err = tlsutil.NewIssuer(bc.CACert, bc.CAKey, policy); err != nil {
+			return nil, fmt.Errorf("invalid Bootstrap config: %w", err)
+		}
+		config.Bootstrap = &bc
+	}
+	if config.Renewal != nil {
+		if config.TLS.GetConfigForClient != nil {
+			return nil, errRenewConfigForClient
+		}
+		renewal := *config.Renewal
+		if renewal.CheckInterval == 0 {
+			renewal.CheckInterval = defaultRenewCheckInterval
+		}
+		config.Renewal = &renewal
+	}
 
 	if config.Logger == nil {
 		config.Logger = log.Default()
@@ -83,19 +227,25 @@ func New(config Config) (*Transport, error) {
 	if config.PoolSweepInterval == 0 {
 		config.PoolSweepInterval = defaultSweepInterval
 	}
-
-	// Set ALPN protocol
-	tlsConf := config.TLS.Clone()
-	tlsConf.NextProtos = []string{alpn}
-
-	// Bind UDP socket
-	udpAddr := &net.UDPAddr{
-		IP:   net.ParseIP(config.BindAddr),
-		Port: config.BindPort,
+	if config.CertExpiryWarning == 0 {
+		config.CertExpiryWarning = defaultCertExpiryWarn
 	}
-	udpConn, err := net.ListenUDP("udp", udpAddr)
-	if err != nil {
-		return nil, fmt.Errorf("failed to bind UDP: %w", err)
+	if config.CertCheckInterval == 0 {
+		config.CertCheckInterval = defaultCertCheck
+	}
+
+	// Bind UDP socket, unless one was supplied
+	packetConn, ownsConn := config.PacketConn, config.OwnPacketConn
+	if packetConn == nil {
+		udpAddr := &net.UDPAddr{
+			IP:   net.ParseIP(config.BindAddr),
+			Port: config.BindPort,
+		}
+		udpConn, err := net.ListenUDP("udp", udpAddr)
+		if err != nil {
+			return nil, fmt.Errorf("failed to bind UDP: %w", err)
+		}
+		packetConn, ownsConn = udpConn, true
 	}
 
 	quicConfig := &quic.Config{
@@ -104,41 +254,76 @@ func New(config Config) (*Transport, error) {
 		EnableDatagrams: true,
 	}
 
-	qTransport := &quic.Transport{Conn: udpConn}
-
-	listener, err := qTransport.Listen(tlsConf, quicConfig)
-	if err != nil {
-		udpConn.Close()
-		return nil, fmt.Errorf("failed to start QUIC listener: %w", err)
-	}
+	qTransport := &quic.Transport{Conn: packetConn}
 
 	t := &Transport{
 		config:     config,
 		logger:     config.Logger,
 		transport:  qTransport,
-		listener:   listener,
+		packetConn: packetConn,
+		ownsConn:   ownsConn,
 		packetCh:   make(chan *memberlist.Packet, config.PacketQueueSize),
 		streamCh:   make(chan net.Conn, config.StreamQueueSize),
 		shutdownCh: make(chan struct{}),
+		channels:   make(map[uint8]*VirtualTransport),
+		routes:     make(map[string]*alpnRoute),
+
+		bootstrapIssuer: bootstrapIssuer,
+
+		alpn:          labelALPN(alpn, config.ClusterLabel),
+		protoALPN:     labelALPN(protocolALPN, config.ClusterLabel),
+		bootstrapALPN: labelALPN(bootstrapALPN, config.ClusterLabel),
+	}
+	t.protocols = map[string]protocolHandler{
+		renewProtocol: t.serveRenewal,
+	}
+	t.setTLSConfig(config.TLS)
+
+	// The listener resolves the current TLS config on every handshake so
+	// that ReloadTLS applies to new inbound connections.
+	listenConf := &tls.Config{
+		MinVersion:         tls.VersionTLS13,
+		NextProtos:         []string{t.alpn},
+		GetConfigForClient: t.serverTLSConfig,
 	}
+	listener, err := qTransport.Listen(listenConf, quicConfig)
+	if err != nil {
+		if ownsConn {
+			packetConn.Close()
+		}
+		return nil, fmt.Errorf("failed to start QUIC listener: %w", err)
+	}
+	t.listener = listener
 
-	t.pool = newConnPool(qTransport, tlsConf, quicConfig, config.Logger, config.MaxConnectionAge, config.PoolSweepInterval, t.startConnHandlers)
+	t.pool = newConnPool(qTransport, t.tlsConfig.Load, quicConfig, config.Logger, config.MaxConnectionAge, config.PoolSweepInterval, t.authorizeConn, t.startConnHandlers)
+	t.protoPool = newConnPool(qTransport, t.protoTLSConfig.Load, quicConfig, config.Logger, config.MaxConnectionAge, config.PoolSweepInterval, t.authorizeConn, t.startProtocolHandlers)
 
 	t.wg.Add(1)
 	go t.acceptLoop()
 
+	if config.CertCheckInterval > 0 {
+		t.wg.Add(1)
+		go t.certMonitor()
+	}
+	if config.Renewal != nil {
+		t.wg.Add(1)
+		go t.renewLoop()
+	}
+
 	return t, nil
 }
 
 // FinalAdvertiseAddr returns the IP and port to advertise.
 func (t *Transport) FinalAdvertiseAddr(ip string, port int) (net.IP, int, error) {
+	addr, err := udpAddrOf(t.listener.Addr())
+	if err != nil {
+		return nil, 0, err
+	}
 	advertiseIP := net.ParseIP(ip)
 	if advertiseIP == nil {
 		// Use the bound address
-		addr := t.listener.Addr().(*net.UDPAddr)
-		if addr.IP.IsUnspecified() {
+		if addr.IP == nil || addr.IP.IsUnspecified() {
 			// Pick a private IP
-			var err error
 			advertiseIP, err = getPrivateIP()
 			if err != nil {
 				return nil, 0, fmt.Errorf("failed to get private IP: %w", err)
@@ -148,11 +333,25 @@ func (t *Transport) FinalAdvertiseAddr(ip string, port int) (net.IP, int, error)
 		}
 	}
 	if port == 0 {
-		port = t.listener.Addr().(*net.UDPAddr).Port
+		port = addr.Port
 	}
 	return advertiseIP, port, nil
 }
 
+// udpAddrOf converts the listener address to a *net.UDPAddr. Supplied
+// packet conns may use other net.Addr types, in which case the address
+// must still be in host:port form.
+func udpAddrOf(addr net.Addr) (*net.UDPAddr, error) {
+	if udpAddr, ok := addr.(*net.UDPAddr); ok {
+		return udpAddr, nil
+	}
+	udpAddr, err := net.ResolveUDPAddr("udp", addr.String())
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse local address %q: %w", addr, err)
+	}
+	return udpAddr, nil
+}
+
 // WriteTo sends a packet to the given address.
 func (t *Transport) WriteTo(b []byte, addr string) (time.Time, error) {
 	return t.WriteToAddress(b, memberlist.Address{Addr: addr})
@@ -160,14 +359,48 @@ func (t *Transport) WriteTo(b []byte, addr string) (time.Time, error) {
 
 // WriteToAddress sends a packet to the given address.
 func (t *Transport) WriteToAddress(b []byte, addr memberlist.Address) (time.Time, error) {
-	conn, err := t.pool.GetOrDial(t.dialContext(), addr.Addr)
+	conn, err := t.pool.GetOrDialName(t.dialContext(), addr.Addr, t.serverName(addr))
 	if err != nil {
-		return time.Time{}, err
+		return time.Time{}, writeError(addr.Addr, t.dialError(addr.Addr, t.alpn, err))
+	}
+	ts, err := sendDatagram(conn, b)
+	if err != nil {
+		return ts, writeError(addr.Addr, err)
+	}
+	return ts, nil
+}
+
+// writeError wraps a packet send failure the way memberlist expects from a
+// UDP write. memberlist only treats a failed ping as a sign that the peer
+// is down when the error is a *net.OpError for a "write" on "udp";
+// anything else is assumed to be a local problem and the probe is
+// abandoned, so a peer whose handshakes fail would never be suspected.
+func writeError(addr string, err error) error {
+	opErr := &net.OpError{Op: "write", Net: "udp", Err: err}
+	if udpAddr, resolveErr := net.ResolveUDPAddr("udp", addr); resolveErr == nil {
+		opErr.Addr = udpAddr
+	}
+	return opErr
+}
+
+// serverName returns the name to verify the peer at addr against, or ""
+// to verify its IP address.
+func (t *Transport) serverName(addr memberlist.Address) string {
+	if t.config.ServerName == nil {
+		return ""
 	}
-	return sendDatagram(conn, b)
+	return t.config.ServerName(addr)
+}
+
+// NodeNameServerName is a Config.ServerName mapping that verifies peers
+// by their memberlist node name, for certificates carrying the node name
+// as a DNS SAN.
+func NodeNameServerName(addr memberlist.Address) string {
+	return addr.Name
 }
 
-// PacketCh returns the channel for inbound packets.
+// PacketCh returns the channel for inbound packets. Their From address is
+// a *PeerAddr identifying the sender.
 func (t *Transport) PacketCh() <-chan *memberlist.Packet {
 	return t.packetCh
 }
@@ -179,9 +412,9 @@ func (t *Transport) DialTimeout(addr string, timeout time.Duration) (net.Conn, e
 
 // DialAddressTimeout opens a stream to the given address.
 func (t *Transport) DialAddressTimeout(addr memberlist.Address, timeout time.Duration) (net.Conn, error) {
-	conn, err := t.pool.GetOrDial(t.dialContext(), addr.Addr)
+	conn, err := t.pool.GetOrDialName(t.dialContext(), addr.Addr, t.serverName(addr))
 	if err != nil {
-		return nil, err
+		return nil, t.dialError(addr.Addr, t.alpn, err)
 	}
 
 	stream, err := conn.OpenStream()
@@ -189,11 +422,7 @@ func (t *Transport) DialAddressTimeout(addr memberlist.Address, timeout time.Dur
 		return nil, err
 	}
 
-	sc := &quicStreamConn{
-		stream:     stream,
-		localAddr:  conn.LocalAddr(),
-		remoteAddr: conn.RemoteAddr(),
-	}
+	sc := newStreamConn(conn, stream)
 
 	if timeout > 0 {
 		_ = sc.SetDeadline(time.Now().Add(timeout))
@@ -202,7 +431,10 @@ func (t *Transport) DialAddressTimeout(addr memberlist.Address, timeout time.Dur
 	return sc, nil
 }
 
-// StreamCh returns the channel for inbound streams.
+// StreamCh returns the channel for inbound streams. Their RemoteAddr is a
+// *PeerAddr identifying the peer, and they have QUICConn and
+// ConnectionState methods returning the underlying connection and its
+// TLS state.
 func (t *Transport) StreamCh() <-chan net.Conn {
 	return t.streamCh
 }
@@ -213,12 +445,113 @@ func (t *Transport) Shutdown() error {
 		close(t.shutdownCh)
 		t.listener.Close()
 		t.pool.close()
+		t.protoPool.close()
 		t.transport.Close()
+		if t.ownsConn {
+			t.packetConn.Close()
+		}
 	})
 	t.wg.Wait()
 	return nil
 }
 
+// ReloadTLS replaces the TLS configuration used for new handshakes, both
+// inbound and outbound. Existing pooled connections keep their session
+// unless reconnect is true, in which case they are closed and redialed on
+// demand with the new configuration; streams in flight on those
+// connections fail.
+func (t *Transport) ReloadTLS(conf *tls.Config, reconnect bool) error {
+	if conf == nil {
+		return fmt.Errorf("TLS config is required")
+	}
+	if err := t.updateTLSConfig(func(*tls.Config) (*tls.Config, error) { return conf, nil }); err != nil {
+		return err
+	}
+	if reconnect {
+		t.pool.closeAll("tls config reloaded")
+		t.protoPool.closeAll("tls config reloaded")
+	}
+	return nil
+}
+
+// updateTLSConfig replaces the TLS config with the one fn returns for
+// the current config, which it must not modify. Updates are serialized,
+// so one derived from the current config never undoes a concurrent
+// ReloadTLS.
+func (t *Transport) updateTLSConfig(fn func(current *tls.Config) (*tls.Config, error)) error {
+	t.tlsMu.Lock()
+	defer t.tlsMu.Unlock()
+	select {
+	case <-t.shutdownCh:
+		return fmt.Errorf("transport shutdown")
+	default:
+	}
+	conf, err := fn(t.tlsConfig.Load())
+	if err != nil {
+		return err
+	}
+	t.setTLSConfig(conf)
+	return nil
+}
+
+func (t *Transport) setTLSConfig(conf *tls.Config) {
+	conf = conf.Clone()
+	conf.NextProtos = []string{t.alpn}
+	t.tlsConfig.Store(conf)
+	protoConf := conf.Clone()
+	protoConf.NextProtos = []string{t.protoALPN}
+	t.protoTLSConfig.Store(protoConf)
+}
+
+// serverTLSConfig returns the config for an inbound handshake, deferring
+// to the configured GetConfigForClient if there is one.
+func (t *Transport) serverTLSConfig(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+	conf := t.tlsConfig.Load()
+	if conf.GetConfigForClient != nil {
+		override, err := conf.GetConfigForClient(hello)
+		if err != nil {
+			return nil, err
+		}
+		if override != nil {
+			conf = override.Clone()
+			conf.NextProtos = []string{t.alpn}
+		}
+	}
+	if t.isBootstrapHello(hello) {
+		return serverOnlyTLSConfig(conf, t.bootstrapALPN), nil
+	}
+	if slices.Contains(hello.SupportedProtos, t.protoALPN) {
+		conf = conf.Clone()
+		conf.NextProtos = []string{t.protoALPN}
+		return conf, nil
+	}
+	if proto, route := t.alpnRoute(hello.SupportedProtos); route != nil {
+		if route.conf != nil {
+			return route.conf, nil
+		}
+		return serverOnlyTLSConfig(conf, proto), nil
+	}
+	t.checkClientLabel(hello)
+	return conf, nil
+}
+
+// CloseRevoked closes pooled connections whose peer presented a
+// certificate for which isRevoked returns true, such as
+// tlsutil.Revocation.IsRevoked, and returns the number closed. New
+// handshakes are only rejected if the TLS config also checks revocation.
+func (t *Transport) CloseRevoked(isRevoked func(*x509.Certificate) bool) int {
+	revoked := func(conn *quic.Conn) bool {
+		for _, cert := range conn.ConnectionState().TLS.PeerCertificates {
+			if isRevoked(cert) {
+				return true
+			}
+		}
+		return false
+	}
+	t.protoPool.closeIf(revoked, "certificate revoked")
+	return t.pool.closeIf(revoked, "certificate revoked")
+}
+
 // ConnPool returns the underlying connection pool.
 func (t *Transport) ConnPool() *ConnPool {
 	return t.pool
@@ -1,14 +1,26 @@
 package memberlistquic
 
 import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"fmt"
+	"io"
+	"math/big"
 	"net"
+	"net/url"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/hashicorp/memberlist"
 	"github.com/quic-go/quic-go"
+	"github.com/wjordan/memberlist-quic/netem"
 	"github.com/wjordan/memberlist-quic/tlsutil"
+	"github.com/wjordan/memberlist-quic/transporttest"
 )
 
 func createTestTransport(t *testing.T, caCert, caKey []byte, nodeName string) (*Transport, *memberlist.Config) {
@@ -53,6 +65,53 @@ func createTestTransport(t *testing.T, caCert, caKey []byte, nodeName string) (*
 	return transport, mlConfig
 }
 
+// createNetemTransport creates a transport attached to an in-memory network
+// at the given virtual IP.
+func createNetemTransport(t *testing.T, network *netem.Network, caCert, caKey []byte, nodeName, ip string) (*Transport, *memberlist.Config) {
+	t.Helper()
+
+	nodeCert, nodeKey, err := tlsutil.GenerateNodeCertWithIPs(caCert, caKey, nodeName, []net.IP{net.ParseIP(ip)}, 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tlsConf, err := tlsutil.MutualTLSConfig(nodeCert, nodeKey, caCert)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := network.ListenPacket(net.JoinHostPort(ip, "7946"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	transport, err := New(Config{
+		PacketConn:        conn,
+		OwnPacketConn:     true,
+		TLS:               tlsConf,
+		MaxIdleTimeout:    5 * time.Second,
+		KeepAlivePeriod:   1 * time.Second,
+		PoolSweepInterval: 5 * time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = transport.Shutdown() })
+
+	mlConfig := memberlist.DefaultLANConfig()
+	mlConfig.Name = nodeName
+	mlConfig.Transport = transport
+	mlConfig.AdvertiseAddr = ip
+	mlConfig.AdvertisePort = 7946
+	mlConfig.LogOutput = io.Discard
+	mlConfig.ProbeInterval = 500 * time.Millisecond
+	mlConfig.ProbeTimeout = 250 * time.Millisecond
+	mlConfig.SuspicionMult = 2
+	mlConfig.RetransmitMult = 2
+
+	return transport, mlConfig
+}
+
 func advertiseAddr(t *testing.T, ml *memberlist.Memberlist) string {
 	t.Helper()
 	cfg := ml.LocalNode()
@@ -271,3 +330,851 @@ func TestConnPoolSharing(t *testing.T) {
 	}
 	stream.Close()
 }
+
+func TestRawPoolStreams(t *testing.T) {
+	caCert, caKey, err := tlsutil.GenerateCA("test-org", 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tr1, _ := createTestTransport(t, caCert, caKey, "node-1")
+	tr2, _ := createTestTransport(t, caCert, caKey, "node-2")
+	ip, port, err := tr1.FinalAdvertiseAddr("", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, err := tr2.ConnPool().GetOrDial(ctx, (&net.UDPAddr{IP: ip, Port: port}).String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Streams on pooled connections reach StreamCh untouched, whatever
+	// they start with
+	for _, msg := range [][]byte{{0xf0, 5, 'x'}, {channelMagic, 1}, []byte("hello")} {
+		stream, err := conn.OpenStreamSync(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := stream.Write(msg); err != nil {
+			t.Fatal(err)
+		}
+		stream.Close()
+
+		select {
+		case in := <-tr1.StreamCh():
+			got, err := io.ReadAll(in)
+			in.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, msg) {
+				t.Errorf("expected stream data %x, got %x", msg, got)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for stream starting with %#x", msg[0])
+		}
+	}
+}
+
+func TestSuppliedPacketConn(t *testing.T) {
+	caCert, caKey, err := tlsutil.GenerateCA("test-org", 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nodeCert, nodeKey, err := tlsutil.GenerateNodeCertWithIPs(caCert, caKey, "node-1", []net.IP{net.IPv4(127, 0, 0, 1)}, 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tlsConf, err := tlsutil.MutualTLSConfig(nodeCert, nodeKey, caCert)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	udpConn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer udpConn.Close()
+
+	if _, err := New(Config{BindPort: 7946, PacketConn: udpConn, TLS: tlsConf}); err == nil {
+		t.Fatal("expected error when both BindPort and PacketConn are set")
+	}
+	if _, err := New(Config{OwnPacketConn: true, TLS: tlsConf}); err == nil {
+		t.Fatal("expected error for OwnPacketConn without PacketConn")
+	}
+
+	transport, err := New(Config{PacketConn: udpConn, TLS: tlsConf})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ip, port, err := transport.FinalAdvertiseAddr("", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ip.Equal(net.IPv4(127, 0, 0, 1)) || port != udpConn.LocalAddr().(*net.UDPAddr).Port {
+		t.Fatalf("unexpected advertise address %s:%d", ip, port)
+	}
+	if err := transport.Shutdown(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The caller still owns the socket after Shutdown
+	if _, err := udpConn.WriteTo([]byte("ping"), udpConn.LocalAddr()); err != nil {
+		t.Fatalf("supplied conn should remain open: %v", err)
+	}
+
+	owned, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	transport, err = New(Config{PacketConn: owned, OwnPacketConn: true, TLS: tlsConf})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := transport.Shutdown(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := owned.WriteTo([]byte("ping"), owned.LocalAddr()); err == nil {
+		t.Fatal("owned conn should be closed by Shutdown")
+	}
+}
+
+func TestPartitionFailureDetection(t *testing.T) {
+	caCert, caKey, err := tlsutil.GenerateCA("test-org", 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	network := netem.NewNetwork(1)
+	network.SetDefaultLink(netem.LinkConfig{
+		Latency: 2 * time.Millisecond,
+		Jitter:  3 * time.Millisecond,
+		Loss:    0.01,
+	})
+
+	var lists []*memberlist.Memberlist
+	for i := 1; i <= 3; i++ {
+		_, cfg := createNetemTransport(t, network, caCert, caKey, fmt.Sprintf("node-%d", i), fmt.Sprintf("10.0.0.%d", i))
+		ml, err := memberlist.Create(cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer func() { _ = ml.Shutdown() }()
+		lists = append(lists, ml)
+	}
+
+	for _, ml := range lists[1:] {
+		if _, err := ml.Join([]string{advertiseAddr(t, lists[0])}); err != nil {
+			t.Fatalf("join failed: %v", err)
+		}
+	}
+
+	waitFor := func(want int, nodes ...*memberlist.Memberlist) bool {
+		deadline := time.Now().Add(15 * time.Second)
+		for time.Now().Before(deadline) {
+			done := true
+			for _, ml := range nodes {
+				if ml.NumMembers() != want {
+					done = false
+				}
+			}
+			if done {
+				return true
+			}
+			time.Sleep(100 * time.Millisecond)
+		}
+		return false
+	}
+
+	if !waitFor(3, lists...) {
+		t.Fatal("cluster did not converge")
+	}
+
+	// Isolate node-3; the others should declare it dead
+	network.Partition([]string{"10.0.0.1:7946", "10.0.0.2:7946"}, []string{"10.0.0.3:7946"})
+	if !waitFor(2, lists[0], lists[1]) {
+		t.Fatalf("partitioned node not detected: node-1 has %d members, node-2 has %d",
+			lists[0].NumMembers(), lists[1].NumMembers())
+	}
+}
+
+func TestConformance(t *testing.T) {
+	caCert, caKey, err := tlsutil.GenerateCA("test-org", 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	transporttest.Run(t, func(t *testing.T) memberlist.NodeAwareTransport {
+		transport, _ := createTestTransport(t, caCert, caKey, "node")
+		return transport
+	})
+}
+
+func TestReloadTLS(t *testing.T) {
+	caCert, caKey, err := tlsutil.GenerateCA("test-org", 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	localhost := []net.IP{net.IPv4(127, 0, 0, 1)}
+
+	cert1, key1, err := tlsutil.GenerateNodeCertWithIPs(caCert, caKey, "node-1-v1", localhost, 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reloadable, err := tlsutil.NewReloadable(cert1, key1, caCert)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tr1, err := New(Config{BindAddr: "127.0.0.1", TLS: reloadable.Config()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = tr1.Shutdown() }()
+	tr2, _ := createTestTransport(t, caCert, caKey, "node-2")
+
+	addr1 := transporttest.Addr(t, tr1)
+	peerName := func() string {
+		t.Helper()
+		conn, err := tr2.ConnPool().GetOrDial(context.Background(), addr1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		name, err := tlsutil.NodeIDFromConn(conn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return name
+	}
+
+	if name := peerName(); name != "node-1-v1" {
+		t.Fatalf("expected node-1-v1, got %s", name)
+	}
+
+	// Rotate the certificate; existing connections are unaffected
+	cert2, key2, err := tlsutil.GenerateNodeCertWithIPs(caCert, caKey, "node-1-v2", localhost, 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := reloadable.Update(cert2, key2, caCert); err != nil {
+		t.Fatal(err)
+	}
+	if name := peerName(); name != "node-1-v1" {
+		t.Fatalf("expected existing connection to keep node-1-v1, got %s", name)
+	}
+
+	// Reloading with reconnect drops pooled connections, so the next dial
+	// handshakes with the new certificate
+	deadline := time.Now().Add(5 * time.Second)
+	for tr1.ConnPool().Len() == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := tr1.ReloadTLS(reloadable.Config(), true); err != nil {
+		t.Fatal(err)
+	}
+	if tr1.ConnPool().Len() != 0 {
+		t.Fatalf("expected empty pool after reconnect, got %d", tr1.ConnPool().Len())
+	}
+	deadline = time.Now().Add(5 * time.Second)
+	for tr2.ConnPool().GetConnection(addr1) != nil && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if name := peerName(); name != "node-1-v2" {
+		t.Fatalf("expected node-1-v2 after reload, got %s", name)
+	}
+
+	if err := tr1.ReloadTLS(nil, false); err == nil {
+		t.Fatal("expected error for nil config")
+	}
+}
+
+// waitForMembers waits until every list sees want members.
+func waitForMembers(t *testing.T, want int, lists ...*memberlist.Memberlist) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		done := true
+		for _, ml := range lists {
+			if ml.NumMembers() != want {
+				done = false
+			}
+		}
+		if done {
+			return
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	for _, ml := range lists {
+		t.Logf("%s sees %d members", ml.LocalNode().Name, ml.NumMembers())
+	}
+	t.Fatalf("cluster did not converge to %d members", want)
+}
+
+func TestCARollover(t *testing.T) {
+	localhost := []net.IP{net.IPv4(127, 0, 0, 1)}
+	oldCA, oldKey, err := tlsutil.GenerateCA("test-org", 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type node struct {
+		name       string
+		reloadable *tlsutil.Reloadable
+		transport  *Transport
+		list       *memberlist.Memberlist
+	}
+	nodes := make([]*node, 3)
+	for i := range nodes {
+		name := fmt.Sprintf("node-%d", i+1)
+		cert, key, err := tlsutil.GenerateNodeCertWithIPs(oldCA, oldKey, name, localhost, 24*time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r, err := tlsutil.NewReloadable(cert, key, oldCA)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tr, err := New(Config{BindAddr: "127.0.0.1", TLS: r.Config()})
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { _ = tr.Shutdown() })
+		// Force re-handshakes so every phase exercises the current trust
+		r.OnReload(func(c *tls.Config) { _ = tr.ReloadTLS(c, true) })
+
+		cfg := memberlist.DefaultLANConfig()
+		cfg.Name = name
+		cfg.BindAddr = "127.0.0.1"
+		cfg.BindPort = 0
+		cfg.AdvertisePort = 0
+		cfg.Transport = tr
+		cfg.LogOutput = io.Discard
+		cfg.ProbeInterval = 500 * time.Millisecond
+		cfg.ProbeTimeout = 250 * time.Millisecond
+		cfg.PushPullInterval = time.Second
+		ml, err := memberlist.Create(cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { _ = ml.Shutdown() })
+		nodes[i] = &node{name: name, reloadable: r, transport: tr, list: ml}
+	}
+	lists := []*memberlist.Memberlist{nodes[0].list, nodes[1].list, nodes[2].list}
+	// Membership alone changes slowly; a push/pull join between every pair
+	// proves that fresh handshakes succeed under the current trust. A join
+	// may race with the peer closing its connections after a reload, so
+	// each pair gets a few attempts.
+	checkHandshakes := func(phase string) {
+		t.Helper()
+		for _, n := range nodes {
+			for _, peer := range nodes {
+				if peer == n {
+					continue
+				}
+				var err error
+				for attempt := 0; attempt < 5; attempt++ {
+					if _, err = n.list.Join([]string{advertiseAddr(t, peer.list)}); err == nil {
+						break
+					}
+					time.Sleep(100 * time.Millisecond)
+				}
+				if err != nil {
+					t.Fatalf("%s: %s could not reach %s: %v", phase, n.name, peer.name, err)
+				}
+			}
+		}
+		waitForMembers(t, 3, lists...)
+	}
+	for _, n := range nodes[1:] {
+		if _, err := n.list.Join([]string{advertiseAddr(t, nodes[0].list)}); err != nil {
+			t.Fatalf("join failed: %v", err)
+		}
+	}
+	waitForMembers(t, 3, lists...)
+
+	// Phase 1: every node trusts both the old and the new CA
+	newCA, newKey, err := tlsutil.GenerateCA("test-org", 48*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bundle := tlsutil.BundlePEM(oldCA, newCA)
+	for _, n := range nodes {
+		n.reloadable.SetCAPool(mustPool(t, bundle))
+	}
+	checkHandshakes("trusting both CAs")
+
+	// Phase 2: nodes are reissued certificates from the new CA one at a
+	// time, while the others still present old-CA certificates
+	for _, n := range nodes {
+		cert, key, err := tlsutil.GenerateNodeCertWithIPs(newCA, newKey, n.name, localhost, 24*time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := n.reloadable.Update(cert, key, bundle); err != nil {
+			t.Fatal(err)
+		}
+		checkHandshakes("reissued " + n.name)
+	}
+
+	// Phase 3: the old CA is dropped everywhere
+	for _, n := range nodes {
+		n.reloadable.SetCAPool(mustPool(t, newCA))
+	}
+	checkHandshakes("trusting the new CA")
+	for _, n := range nodes {
+		n.transport.ConnPool().Range(func(addr string, conn *quic.Conn) bool {
+			chains := conn.ConnectionState().TLS.VerifiedChains
+			if len(chains) == 0 || !bytes.Equal(chains[0][len(chains[0])-1].Raw, mustCerts(t, newCA)[0].Raw) {
+				t.Errorf("%s: connection to %s does not chain to the new CA", n.name, addr)
+			}
+			return true
+		})
+	}
+
+	// A node still holding an old-CA certificate can no longer join
+	_, cfg := createTestTransport(t, oldCA, oldKey, "node-old")
+	cfg.LogOutput = io.Discard
+	stale, err := memberlist.Create(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = stale.Shutdown() }()
+	if _, err := stale.Join([]string{advertiseAddr(t, nodes[0].list)}); err == nil {
+		t.Fatal("expected join with an old-CA certificate to fail")
+	}
+}
+
+func mustPool(t *testing.T, bundle []byte) *x509.CertPool {
+	t.Helper()
+	pool, err := tlsutil.CertPoolFromPEM(bundle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pool
+}
+
+func mustCerts(t *testing.T, bundle []byte) []*x509.Certificate {
+	t.Helper()
+	certs, err := tlsutil.ParseCertificates(bundle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return certs
+}
+
+func TestCloseRevoked(t *testing.T) {
+	caCert, caKey, err := tlsutil.GenerateCA("test-org", 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newRevocableTransport := func(name string) (*Transport, *tlsutil.Revocation, []byte) {
+		t.Helper()
+		cert, key, err := tlsutil.GenerateNodeCertWithIPs(caCert, caKey, name, []net.IP{net.IPv4(127, 0, 0, 1)}, time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+		conf, err := tlsutil.MutualTLSConfig(cert, key, caCert)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rev := tlsutil.NewRevocation()
+		tr, err := New(Config{BindAddr: "127.0.0.1", TLS: rev.Apply(conf)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { _ = tr.Shutdown() })
+		return tr, rev, cert
+	}
+	tr1, rev1, _ := newRevocableTransport("node-1")
+	tr2, _, cert2 := newRevocableTransport("node-2")
+
+	closed := make(chan int, 1)
+	rev1.OnUpdate(func() { closed <- tr1.CloseRevoked(rev1.IsRevoked) })
+
+	addr2 := transporttest.Addr(t, tr2)
+	conn, err := tr1.DialTimeout(addr2, 5*time.Second)
+	if err != nil {
+		t.Fatalf("dial before revocation: %v", err)
+	}
+	conn.Close()
+
+	certs, err := tlsutil.ParseCertificates(cert2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	crl, err := tlsutil.GenerateCRL(caCert, caKey, []*big.Int{certs[0].SerialNumber}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := rev1.Update(crl, caCert); err != nil {
+		t.Fatal(err)
+	}
+	if n := <-closed; n != 1 {
+		t.Fatalf("expected 1 revoked connection closed, got %d", n)
+	}
+	if tr1.ConnPool().GetConnection(addr2) != nil {
+		t.Fatal("expected revoked connection to be removed from the pool")
+	}
+
+	// Both directions now fail: node-1 rejects node-2's certificate as a
+	// server and as a client
+	if _, err := tr1.DialTimeout(addr2, 2*time.Second); err == nil {
+		t.Fatal("expected dial to revoked peer to fail")
+	}
+	// A TLS 1.3 client completes its side of the handshake before the
+	// server verifies its certificate, so the dial itself may succeed
+	addr1 := transporttest.Addr(t, tr1)
+	if conn, err := tr2.DialTimeout(addr1, 2*time.Second); err == nil {
+		conn.Close()
+		deadline := time.Now().Add(5 * time.Second)
+		for tr2.ConnPool().GetConnection(addr1) != nil {
+			if time.Now().After(deadline) {
+				t.Fatal("expected connection from revoked peer to be rejected")
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+	}
+}
+
+// dialBothWays has tr1 and tr2 dial each other at once, so that each
+// may also accept the other's connection, and returns the connections
+// they dialed.
+func dialBothWays(t *testing.T, tr1, tr2 *Transport) (*quic.Conn, *quic.Conn) {
+	t.Helper()
+	addr1, addr2 := transporttest.Addr(t, tr1), transporttest.Addr(t, tr2)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	var conn1, conn2 *quic.Conn
+	var err1, err2 error
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		conn1, err1 = tr1.pool.GetOrDial(ctx, addr2)
+	}()
+	go func() {
+		defer wg.Done()
+		conn2, err2 = tr2.pool.GetOrDial(ctx, addr1)
+	}()
+	wg.Wait()
+	if err := errors.Join(err1, err2); err != nil {
+		t.Fatal(err)
+	}
+
+	// A stream reaching the peer shows it has taken on the connection
+	for _, c := range []struct {
+		conn *quic.Conn
+		peer *Transport
+	}{{conn1, tr2}, {conn2, tr1}} {
+		stream, err := c.conn.OpenStreamSync(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := stream.Write([]byte{0}); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case sc := <-c.peer.StreamCh():
+			sc.Close()
+		case <-ctx.Done():
+			t.Fatal("timed out waiting for stream")
+		}
+		stream.Close()
+	}
+	return conn1, conn2
+}
+
+// waitClosed waits for each of conns to close.
+func waitClosed(t *testing.T, conns ...*quic.Conn) {
+	t.Helper()
+	for _, conn := range conns {
+		select {
+		case <-conn.Context().Done():
+		case <-time.After(5 * time.Second):
+			t.Fatalf("connection to %s still open", conn.RemoteAddr())
+		}
+	}
+}
+
+func TestCloseRevokedSimultaneousDial(t *testing.T) {
+	caCert, caKey, err := tlsutil.GenerateCA("test-org", 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr1, _ := createTestTransport(t, caCert, caKey, "node-1")
+	tr2, _ := createTestTransport(t, caCert, caKey, "node-2")
+	conn1, conn2 := dialBothWays(t, tr1, tr2)
+
+	// node-2 closes the connection it dialed and the one it accepted,
+	// whichever it kept in use
+	if n := tr2.CloseRevoked(func(*x509.Certificate) bool { return true }); n == 0 {
+		t.Fatal("expected revoked connections to be closed")
+	}
+	waitClosed(t, conn1, conn2)
+	if peers := tr2.PeerCertificates(); len(peers) != 0 {
+		t.Fatalf("expected no peer certificates after revocation, got %d", len(peers))
+	}
+}
+
+func TestReloadTLSSimultaneousDial(t *testing.T) {
+	caCert, caKey, err := tlsutil.GenerateCA("test-org", 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr1, _ := createTestTransport(t, caCert, caKey, "node-1")
+	tr2, _ := createTestTransport(t, caCert, caKey, "node-2")
+	conn1, conn2 := dialBothWays(t, tr1, tr2)
+
+	// Reconnecting closes the accepted connection node-2 did not keep in
+	// use as well, so none keeps the old session
+	if err := tr2.ReloadTLS(tr2.tlsConfig.Load(), true); err != nil {
+		t.Fatal(err)
+	}
+	waitClosed(t, conn1, conn2)
+}
+
+// failedRemote mirrors memberlist's classification of send errors: only
+// errors it attributes to the remote node let a failed probe count
+// against that node.
+func failedRemote(err error) bool {
+	var opErr *net.OpError
+	if !errors.As(err, &opErr) {
+		return false
+	}
+	switch {
+	case strings.HasPrefix(opErr.Net, "tcp"):
+		return opErr.Op == "dial" || opErr.Op == "read" || opErr.Op == "write"
+	case strings.HasPrefix(opErr.Net, "udp"):
+		return opErr.Op == "write"
+	}
+	return false
+}
+
+func TestHandshakeFailureSuspectsPeer(t *testing.T) {
+	caCert, caKey, err := tlsutil.GenerateCA("test-org", 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr1, cfg1 := createTestTransport(t, caCert, caKey, "node-1")
+	tr2, cfg2 := createTestTransport(t, caCert, caKey, "node-2")
+	ml1, err := memberlist.Create(cfg1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ml1.Shutdown()
+	ml2, err := memberlist.Create(cfg2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ml2.Shutdown()
+	if _, err := ml2.Join([]string{advertiseAddr(t, ml1)}); err != nil {
+		t.Fatal(err)
+	}
+	waitForMembers(t, 2, ml1, ml2)
+
+	// node-2 moves to a CA node-1 does not trust, so every new handshake
+	// between them fails while node-2 keeps running
+	otherCA, otherKey, err := tlsutil.GenerateCA("other-org", 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, key, err := tlsutil.GenerateNodeCertWithIPs(otherCA, otherKey, "node-2", []net.IP{net.IPv4(127, 0, 0, 1)}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf, err := tlsutil.MutualTLSConfig(cert, key, otherCA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tr2.ReloadTLS(conf, true); err != nil {
+		t.Fatal(err)
+	}
+	addr2 := transporttest.Addr(t, tr2)
+	deadline := time.Now().Add(5 * time.Second)
+	for tr1.ConnPool().GetConnection(addr2) != nil && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// The failed send is reported as a UDP write error, which memberlist
+	// attributes to the peer rather than abandoning the probe
+	_, err = tr1.WriteTo([]byte("ping"), addr2)
+	if err == nil || !failedRemote(err) {
+		t.Fatalf("expected a remote failure sending to node-2, got %v", err)
+	}
+
+	deadline = time.Now().Add(15 * time.Second)
+	for {
+		// Members omits dead nodes
+		alive := false
+		for _, node := range ml1.Members() {
+			if node.Name == "node-2" {
+				alive = node.State == memberlist.StateAlive
+			}
+		}
+		if !alive {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expected node-1 to suspect node-2 after failed handshakes")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func TestSPIFFECluster(t *testing.T) {
+	caCert, caKey, err := tlsutil.GenerateCA("example.org", 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// SVIDs carry no IP SANs; node names are derived from the SPIFFE ID
+	lists := make([]*memberlist.Memberlist, 2)
+	transports := make([]*Transport, 2)
+	for i := range lists {
+		id, err := tlsutil.NewSPIFFEID("example.org", "memberlist", fmt.Sprintf("node-%d", i+1))
+		if err != nil {
+			t.Fatal(err)
+		}
+		svid, key, err := tlsutil.GenerateNodeCertWithOptions(caCert, caKey, tlsutil.NodeCertOptions{
+			URIs:     []*url.URL{id},
+			Validity: time.Hour,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		conf, err := tlsutil.SPIFFEConfig(svid, key, caCert, "example.org")
+		if err != nil {
+			t.Fatal(err)
+		}
+		tr, err := New(Config{BindAddr: "127.0.0.1", TLS: conf})
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { _ = tr.Shutdown() })
+		transports[i] = tr
+
+		cfg := memberlist.DefaultLANConfig()
+		cfg.Name, err = tlsutil.SPIFFENodeName(id, "/memberlist")
+		if err != nil {
+			t.Fatal(err)
+		}
+		cfg.BindAddr = "127.0.0.1"
+		cfg.BindPort = 0
+		cfg.AdvertisePort = 0
+		cfg.Transport = tr
+		cfg.LogOutput = io.Discard
+		lists[i], err = memberlist.Create(cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { _ = lists[i].Shutdown() })
+	}
+	if _, err := lists[1].Join([]string{advertiseAddr(t, lists[0])}); err != nil {
+		t.Fatalf("join failed: %v", err)
+	}
+	waitForMembers(t, 2, lists...)
+
+	// Each peer's SPIFFE ID maps back to its memberlist node name
+	transports[0].ConnPool().Range(func(addr string, conn *quic.Conn) bool {
+		nodeID, err := tlsutil.NodeIDFromConn(conn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		id, err := tlsutil.ParseSPIFFEID(nodeID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		name, err := tlsutil.SPIFFENodeName(id, "/memberlist")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if name != lists[1].LocalNode().Name {
+			t.Errorf("peer %s maps to %q, expected %q", addr, name, lists[1].LocalNode().Name)
+		}
+		return true
+	})
+
+	// Dialing by node name checks the name the SPIFFE ID maps to, from
+	// the first connection on
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	addr1 := transporttest.Addr(t, transports[1])
+	if _, err := transports[0].protoPool.GetOrDialName(ctx, addr1, lists[0].LocalNode().Name); err == nil {
+		t.Fatal("expected dial to the wrong node name to fail")
+	}
+	if _, err := transports[0].protoPool.GetOrDialName(ctx, addr1, lists[1].LocalNode().Name); err != nil {
+		t.Fatalf("dial by node name: %v", err)
+	}
+}
+
+func TestServerName(t *testing.T) {
+	caCert, caKey, err := tlsutil.GenerateCA("test-org", 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Certificates name the node but carry no IP SANs
+	lists := make([]*memberlist.Memberlist, 3)
+	transports := make([]*Transport, 3)
+	for i := range lists {
+		name := fmt.Sprintf("node-%d", i+1)
+		cert, key, err := tlsutil.GenerateNodeCertWithOptions(caCert, caKey, tlsutil.NodeCertOptions{
+			NodeID:   name,
+			DNSNames: []string{name},
+			Validity: time.Hour,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		conf, err := tlsutil.MutualTLSConfig(cert, key, caCert)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tr, err := New(Config{BindAddr: "127.0.0.1", TLS: conf, ServerName: NodeNameServerName})
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { _ = tr.Shutdown() })
+		transports[i] = tr
+
+		cfg := memberlist.DefaultLANConfig()
+		cfg.Name = name
+		cfg.BindAddr = "127.0.0.1"
+		cfg.BindPort = 0
+		cfg.AdvertisePort = 0
+		cfg.Transport = tr
+		cfg.LogOutput = io.Discard
+		lists[i], err = memberlist.Create(cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { _ = lists[i].Shutdown() })
+	}
+
+	// Joining by address alone verifies the IP, which the certificates
+	// lack; naming the seed lets it be verified by name
+	seed := advertiseAddr(t, lists[0])
+	if _, err := lists[1].Join([]string{seed}); err == nil {
+		t.Fatal("expected join without a node name to fail")
+	}
+	for _, ml := range lists[1:] {
+		if _, err := ml.Join([]string{"node-1/" + seed}); err != nil {
+			t.Fatalf("join failed: %v", err)
+		}
+	}
+	waitForMembers(t, 3, lists...)
+
+	// A pooled connection is not reused for a different node at the same
+	// address, as happens when a node takes over another's IP
+	addr2 := advertiseAddr(t, lists[1])
+	if _, err := transports[0].WriteToAddress([]byte("ping"), memberlist.Address{Addr: addr2, Name: "node-2"}); err != nil {
+		t.Fatalf("write to node-2 by name: %v", err)
+	}
+	if _, err := transports[0].WriteToAddress([]byte("ping"), memberlist.Address{Addr: addr2, Name: "node-3"}); err == nil {
+		t.Fatal("expected write to node-3 at node-2's address to fail")
+	}
+}
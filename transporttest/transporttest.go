@@ -0,0 +1,326 @@
+// Package transporttest provides a conformance suite for implementations of
+// memberlist.NodeAwareTransport. It is used to test memberlistquic.Transport
+// and can be run against wrappers around it or any other transport.
+package transporttest
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/memberlist"
+)
+
+// Factory creates a new, started transport listening on a loopback address
+// reachable by the other transports it creates. Factories should register
+// a cleanup that calls Shutdown; the suite may shut a transport down
+// earlier, so Shutdown must tolerate being called twice.
+type Factory func(t *testing.T) memberlist.NodeAwareTransport
+
+const (
+	// LargePacketSize exceeds any QUIC datagram or Ethernet MTU but fits
+	// in a single UDP packet, so transports must carry it intact.
+	LargePacketSize = 16 * 1024
+
+	timeout = 5 * time.Second
+)
+
+// Run runs the conformance suite against transports created by factory.
+func Run(t *testing.T, factory Factory) {
+	t.Run("AdvertiseAddr", func(t *testing.T) { testAdvertiseAddr(t, factory) })
+	t.Run("PacketDelivery", func(t *testing.T) { testPacketDelivery(t, factory) })
+	t.Run("PacketFrom", func(t *testing.T) { testPacketFrom(t, factory) })
+	t.Run("LargePacket", func(t *testing.T) { testLargePacket(t, factory) })
+	t.Run("ConcurrentWriteTo", func(t *testing.T) { testConcurrentWriteTo(t, factory) })
+	t.Run("StreamRoundTrip", func(t *testing.T) { testStreamRoundTrip(t, factory) })
+	t.Run("StreamDeadline", func(t *testing.T) { testStreamDeadline(t, factory) })
+	t.Run("Shutdown", func(t *testing.T) { testShutdown(t, factory) })
+}
+
+// Addr returns the host:port a transport advertises.
+func Addr(t *testing.T, tr memberlist.NodeAwareTransport) string {
+	t.Helper()
+	ip, port, err := tr.FinalAdvertiseAddr("", 0)
+	if err != nil {
+		t.Fatalf("FinalAdvertiseAddr: %v", err)
+	}
+	return net.JoinHostPort(ip.String(), strconv.Itoa(port))
+}
+
+// sendUntilReceived resends b until a matching packet arrives, since
+// packets may be legitimately dropped.
+func sendUntilReceived(t *testing.T, from, to memberlist.NodeAwareTransport, b []byte) *memberlist.Packet {
+	t.Helper()
+	addr := memberlist.Address{Addr: Addr(t, to)}
+	deadline := time.After(timeout)
+	retry := time.NewTicker(200 * time.Millisecond)
+	defer retry.Stop()
+
+	for {
+		if _, err := from.WriteToAddress(b, addr); err != nil {
+			t.Fatalf("WriteToAddress: %v", err)
+		}
+		select {
+		case p := <-to.PacketCh():
+			if !bytes.Equal(p.Buf, b) {
+				t.Fatalf("received corrupted packet: got %d bytes, want %d", len(p.Buf), len(b))
+			}
+			return p
+		case <-retry.C:
+		case <-deadline:
+			t.Fatalf("packet of %d bytes not received within %s", len(b), timeout)
+		}
+	}
+}
+
+func testAdvertiseAddr(t *testing.T, factory Factory) {
+	tr := factory(t)
+	ip, port, err := tr.FinalAdvertiseAddr("", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ip == nil || port == 0 {
+		t.Fatalf("expected a concrete address, got %v:%d", ip, port)
+	}
+
+	ip, port, err = tr.FinalAdvertiseAddr("192.0.2.1", 1234)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ip.Equal(net.ParseIP("192.0.2.1")) || port != 1234 {
+		t.Fatalf("explicit advertise address not honored, got %v:%d", ip, port)
+	}
+}
+
+func testPacketDelivery(t *testing.T, factory Factory) {
+	a, b := factory(t), factory(t)
+	p := sendUntilReceived(t, a, b, []byte("ping"))
+	if p.Timestamp.IsZero() {
+		t.Fatal("packet has no timestamp")
+	}
+
+	// WriteTo without a node name must also work
+	deadline := time.After(timeout)
+	for {
+		if _, err := b.WriteTo([]byte("pong"), Addr(t, a)); err != nil {
+			t.Fatalf("WriteTo: %v", err)
+		}
+		select {
+		case p := <-a.PacketCh():
+			if string(p.Buf) != "pong" {
+				t.Fatalf("expected pong, got %q", p.Buf)
+			}
+			return
+		case <-time.After(200 * time.Millisecond):
+		case <-deadline:
+			t.Fatal("reply not received")
+		}
+	}
+}
+
+func testPacketFrom(t *testing.T, factory Factory) {
+	a, b := factory(t), factory(t)
+	p := sendUntilReceived(t, a, b, []byte("who"))
+	if p.From == nil {
+		t.Fatal("packet has no From address")
+	}
+	// memberlist replies to From.String(), so it must be the sender's
+	// advertised address
+	if got, want := p.From.String(), Addr(t, a); got != want {
+		t.Fatalf("expected From %s, got %s", want, got)
+	}
+}
+
+func testLargePacket(t *testing.T, factory Factory) {
+	a, b := factory(t), factory(t)
+	payload := make([]byte, LargePacketSize)
+	for i := range payload {
+		payload[i] = byte(i)
+	}
+	sendUntilReceived(t, a, b, payload)
+}
+
+func testConcurrentWriteTo(t *testing.T, factory Factory) {
+	const (
+		writers   = 8
+		perWriter = 16
+	)
+	a, b := factory(t), factory(t)
+
+	// Establish any connection state before the concurrent burst
+	sendUntilReceived(t, a, b, []byte("warmup"))
+
+	addr := Addr(t, b)
+	errCh := make(chan error, writers)
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < perWriter; i++ {
+				msg := []byte(fmt.Sprintf("writer-%d-msg-%d", w, i))
+				if _, err := a.WriteTo(msg, addr); err != nil {
+					errCh <- err
+					return
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	close(errCh)
+	for err := range errCh {
+		t.Fatalf("concurrent WriteTo: %v", err)
+	}
+
+	// Packets may be dropped, but those that arrive must be intact and
+	// not duplicated
+	seen := make(map[string]bool)
+	idle := time.NewTimer(time.Second)
+	defer idle.Stop()
+collect:
+	for {
+		select {
+		case p := <-b.PacketCh():
+			var w, i int
+			if _, err := fmt.Sscanf(string(p.Buf), "writer-%d-msg-%d", &w, &i); err != nil ||
+				w >= writers || i >= perWriter {
+				if string(p.Buf) == "warmup" {
+					continue
+				}
+				t.Fatalf("received corrupted packet %q", p.Buf)
+			}
+			if seen[string(p.Buf)] {
+				t.Fatalf("received duplicate packet %q", p.Buf)
+			}
+			seen[string(p.Buf)] = true
+			if len(seen) == writers*perWriter {
+				break collect
+			}
+			idle.Reset(time.Second)
+		case <-idle.C:
+			break collect
+		}
+	}
+	if len(seen) == 0 {
+		t.Fatal("no packets received from concurrent writers")
+	}
+}
+
+func testStreamRoundTrip(t *testing.T, factory Factory) {
+	a, b := factory(t), factory(t)
+
+	conn, err := a.DialAddressTimeout(memberlist.Address{Addr: Addr(t, b)}, timeout)
+	if err != nil {
+		t.Fatalf("DialAddressTimeout: %v", err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(timeout))
+
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	var remote net.Conn
+	select {
+	case remote = <-b.StreamCh():
+	case <-time.After(timeout):
+		t.Fatal("stream not accepted")
+	}
+	defer remote.Close()
+	_ = remote.SetDeadline(time.Now().Add(timeout))
+
+	if remote.RemoteAddr() == nil || remote.LocalAddr() == nil {
+		t.Fatal("accepted stream is missing addresses")
+	}
+
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(remote, buf); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if string(buf) != "ping" {
+		t.Fatalf("expected ping, got %q", buf)
+	}
+
+	if _, err := remote.Write([]byte("pong")); err != nil {
+		t.Fatalf("write reply: %v", err)
+	}
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		t.Fatalf("read reply: %v", err)
+	}
+	if string(buf) != "pong" {
+		t.Fatalf("expected pong, got %q", buf)
+	}
+}
+
+func testStreamDeadline(t *testing.T, factory Factory) {
+	a, b := factory(t), factory(t)
+
+	conn, err := a.DialAddressTimeout(memberlist.Address{Addr: Addr(t, b)}, timeout)
+	if err != nil {
+		t.Fatalf("DialAddressTimeout: %v", err)
+	}
+	defer conn.Close()
+
+	if err := conn.SetReadDeadline(time.Now().Add(50 * time.Millisecond)); err != nil {
+		t.Fatalf("SetReadDeadline: %v", err)
+	}
+	start := time.Now()
+	_, err = conn.Read(make([]byte, 1))
+	if err == nil {
+		t.Fatal("expected read to time out")
+	}
+	var nerr net.Error
+	if !errors.As(err, &nerr) || !nerr.Timeout() {
+		t.Fatalf("expected timeout error, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > timeout {
+		t.Fatalf("read deadline took %s to fire", elapsed)
+	}
+
+	// Clearing the deadline makes the stream usable again
+	if err := conn.SetDeadline(time.Time{}); err != nil {
+		t.Fatalf("SetDeadline: %v", err)
+	}
+	if _, err := conn.Write([]byte("x")); err != nil {
+		t.Fatalf("write after deadline: %v", err)
+	}
+
+	// Accept the remote end so transports that block on an unread
+	// StreamCh can shut down
+	select {
+	case remote := <-b.StreamCh():
+		remote.Close()
+	case <-time.After(timeout):
+		t.Fatal("stream not accepted")
+	}
+}
+
+func testShutdown(t *testing.T, factory Factory) {
+	a, b := factory(t), factory(t)
+	sendUntilReceived(t, a, b, []byte("before"))
+	addr := Addr(t, b)
+
+	if err := a.Shutdown(); err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := a.WriteTo([]byte("after"), addr)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Fatal("expected WriteTo to fail after Shutdown")
+		}
+	case <-time.After(timeout):
+		t.Fatal("WriteTo blocked after Shutdown")
+	}
+}
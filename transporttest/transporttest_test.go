@@ -0,0 +1,25 @@
+package transporttest
+
+import (
+	"io"
+	"log"
+	"testing"
+
+	"github.com/hashicorp/memberlist"
+)
+
+// TestNetTransport validates the suite itself against memberlist's
+// reference transport.
+func TestNetTransport(t *testing.T) {
+	Run(t, func(t *testing.T) memberlist.NodeAwareTransport {
+		tr, err := memberlist.NewNetTransport(&memberlist.NetTransportConfig{
+			BindAddrs: []string{"127.0.0.1"},
+			Logger:    log.New(io.Discard, "", 0),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { _ = tr.Shutdown() })
+		return tr
+	})
+}
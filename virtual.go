@@ -0,0 +1,208 @@
+package memberlistquic
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/memberlist"
+	"github.com/quic-go/quic-go"
+)
+
+// channelMagic prefixes packets of a VirtualTransport, followed by its
+// channel ID. Memberlist messages never start with it.
+const channelMagic byte = 0xf2
+
+// channelProtocol returns the name of the stream protocol carrying
+// streams of the virtual transport for channel id.
+func channelProtocol(id uint8) string {
+	return fmt.Sprintf("memberlist-channel/%d", id)
+}
+
+// VirtualTransport is a memberlist.NodeAwareTransport sharing a
+// Transport's socket, certificates and connection pool with other
+// memberlist instances, such as separate LAN and WAN pools. Its packets
+// carry a channel ID and its streams a protocol name, and both are
+// delivered to its own channels.
+// Create one with Transport.NewVirtual.
+type VirtualTransport struct {
+	t          *Transport
+	id         uint8
+	packetCh   chan *memberlist.Packet
+	streamCh   chan net.Conn
+	shutdownCh chan struct{}
+	shutdown   sync.Once
+}
+
+var _ memberlist.NodeAwareTransport = (*VirtualTransport)(nil)
+
+// NewVirtual creates a virtual transport for channel id, which must be
+// between 1 and 255 and agree across all members of its memberlist.
+// Channel 0 is the Transport itself, which keeps serving its own
+// memberlist. Shutting down a virtual transport only releases its
+// channel; shutting down the Transport stops all of them.
+func (t *Transport) NewVirtual(id uint8) (*VirtualTransport, error) {
+	if id == 0 {
+		return nil, fmt.Errorf("channel 0 is reserved for the transport itself")
+	}
+	t.channelsMu.Lock()
+	defer t.channelsMu.Unlock()
+	select {
+	case <-t.shutdownCh:
+		return nil, fmt.Errorf("transport shutdown")
+	default:
+	}
+	if _, ok := t.channels[id]; ok {
+		return nil, fmt.Errorf("channel %d is already in use", id)
+	}
+	v := &VirtualTransport{
+		t:          t,
+		id:         id,
+		packetCh:   make(chan *memberlist.Packet, t.config.PacketQueueSize),
+		streamCh:   make(chan net.Conn, t.config.StreamQueueSize),
+		shutdownCh: make(chan struct{}),
+	}
+	t.protocolsMu.Lock()
+	defer t.protocolsMu.Unlock()
+	if _, ok := t.protocols[channelProtocol(id)]; ok {
+		return nil, fmt.Errorf("protocol %q is already registered", channelProtocol(id))
+	}
+	t.protocols[channelProtocol(id)] = v.serveStream
+	t.channels[id] = v
+	return v, nil
+}
+
+func (t *Transport) channel(id uint8) *VirtualTransport {
+	t.channelsMu.Lock()
+	defer t.channelsMu.Unlock()
+	return t.channels[id]
+}
+
+// deliverPacket routes a packet received on conn to the transport's
+// packet channel, to a virtual transport's if it carries a channel ID, or
+// to the broadcaster. Packets for unknown channels are dropped.
+func (t *Transport) deliverPacket(conn *quic.Conn, buf []byte) {
+	if len(buf) > 0 && buf[0] == broadcastMagic {
+		if b := t.broadcaster.Load(); b != nil {
+			b.deliverPacket(conn, buf[1:])
+		}
+		return
+	}
+
+	packetCh, doneCh := t.packetCh, t.shutdownCh
+	if len(buf) > 0 && buf[0] == channelMagic {
+		if len(buf) < 2 {
+			return
+		}
+		v := t.channel(buf[1])
+		if v == nil {
+			return
+		}
+		buf = buf[2:]
+		packetCh, doneCh = v.packetCh, v.shutdownCh
+	}
+
+	select {
+	case packetCh <- &memberlist.Packet{
+		Buf:       buf,
+		From:      newPeerAddr(conn),
+		Timestamp: time.Now(),
+	}:
+	case <-doneCh:
+	case <-t.shutdownCh:
+	}
+}
+
+// serveStream hands an inbound stream for this channel to its stream
+// channel.
+func (v *VirtualTransport) serveStream(conn *quic.Conn, stream *quic.Stream) {
+	select {
+	case v.streamCh <- newStreamConn(conn, stream):
+	case <-v.shutdownCh:
+		stream.Close()
+	case <-v.t.shutdownCh:
+		stream.Close()
+	}
+}
+
+// FinalAdvertiseAddr returns the underlying transport's address.
+func (v *VirtualTransport) FinalAdvertiseAddr(ip string, port int) (net.IP, int, error) {
+	return v.t.FinalAdvertiseAddr(ip, port)
+}
+
+// WriteTo sends a packet to the given address on this channel.
+func (v *VirtualTransport) WriteTo(b []byte, addr string) (time.Time, error) {
+	return v.WriteToAddress(b, memberlist.Address{Addr: addr})
+}
+
+// WriteToAddress sends a packet to the given address on this channel.
+func (v *VirtualTransport) WriteToAddress(b []byte, addr memberlist.Address) (time.Time, error) {
+	if err := v.checkShutdown(); err != nil {
+		return time.Time{}, err
+	}
+	buf := make([]byte, 0, len(b)+2)
+	buf = append(buf, channelMagic, v.id)
+	return v.t.WriteToAddress(append(buf, b...), addr)
+}
+
+// PacketCh returns the channel for this channel's inbound packets.
+func (v *VirtualTransport) PacketCh() <-chan *memberlist.Packet {
+	return v.packetCh
+}
+
+// DialTimeout opens a stream to the given address on this channel.
+func (v *VirtualTransport) DialTimeout(addr string, timeout time.Duration) (net.Conn, error) {
+	return v.DialAddressTimeout(memberlist.Address{Addr: addr}, timeout)
+}
+
+// DialAddressTimeout opens a stream to the given address on this channel.
+func (v *VirtualTransport) DialAddressTimeout(addr memberlist.Address, timeout time.Duration) (net.Conn, error) {
+	if err := v.checkShutdown(); err != nil {
+		return nil, err
+	}
+	ctx := v.t.dialContext()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	conn, err := v.t.dialProtocol(ctx, addr.Addr, v.t.serverName(addr))
+	if err != nil {
+		return nil, err
+	}
+	stream, err := openProtocolStream(ctx, conn, channelProtocol(v.id))
+	if err != nil {
+		return nil, err
+	}
+	return newStreamConn(conn, stream), nil
+}
+
+// StreamCh returns the channel for this channel's inbound streams.
+func (v *VirtualTransport) StreamCh() <-chan net.Conn {
+	return v.streamCh
+}
+
+func (v *VirtualTransport) checkShutdown() error {
+	select {
+	case <-v.shutdownCh:
+		return fmt.Errorf("transport shutdown")
+	default:
+		return nil
+	}
+}
+
+// Shutdown releases the channel. The underlying Transport keeps running.
+func (v *VirtualTransport) Shutdown() error {
+	v.shutdown.Do(func() {
+		v.t.channelsMu.Lock()
+		delete(v.t.channels, v.id)
+		v.t.channelsMu.Unlock()
+		v.t.protocolsMu.Lock()
+		delete(v.t.protocols, channelProtocol(v.id))
+		v.t.protocolsMu.Unlock()
+		close(v.shutdownCh)
+	})
+	return nil
+}
@@ -0,0 +1,107 @@
+package memberlistquic
+
+import (
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/memberlist"
+	"github.com/wjordan/memberlist-quic/tlsutil"
+	"github.com/wjordan/memberlist-quic/transporttest"
+)
+
+func TestVirtualTransports(t *testing.T) {
+	caCert, caKey, err := tlsutil.GenerateCA("test-org", 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Each node runs a LAN pool on the transport and a WAN pool on a
+	// virtual transport, over one socket and connection per peer
+	newList := func(name string, tr memberlist.NodeAwareTransport) *memberlist.Memberlist {
+		cfg := memberlist.DefaultLANConfig()
+		cfg.Name = name
+		cfg.BindAddr = "127.0.0.1"
+		cfg.BindPort = 0
+		cfg.AdvertisePort = 0
+		cfg.Transport = tr
+		cfg.LogOutput = io.Discard
+		ml, err := memberlist.Create(cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { _ = ml.Shutdown() })
+		return ml
+	}
+	var transports []*Transport
+	var lan, wan []*memberlist.Memberlist
+	for i := range 3 {
+		name := fmt.Sprintf("node-%d", i+1)
+		tr, _ := createTestTransport(t, caCert, caKey, name)
+		v, err := tr.NewVirtual(1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		transports = append(transports, tr)
+		lan = append(lan, newList(name, tr))
+		wan = append(wan, newList(name+".dc1", v))
+	}
+
+	for i := 1; i < 3; i++ {
+		if _, err := lan[i].Join([]string{advertiseAddr(t, lan[0])}); err != nil {
+			t.Fatalf("LAN join failed: %v", err)
+		}
+		if _, err := wan[i].Join([]string{advertiseAddr(t, wan[0])}); err != nil {
+			t.Fatalf("WAN join failed: %v", err)
+		}
+	}
+	waitForMembers(t, 3, lan...)
+	waitForMembers(t, 3, wan...)
+
+	for _, node := range wan[0].Members() {
+		if len(node.Name) < 4 || node.Name[len(node.Name)-4:] != ".dc1" {
+			t.Errorf("LAN member %s leaked into WAN pool", node.Name)
+		}
+	}
+	for _, tr := range transports {
+		if n := tr.pool.Len(); n > 2 {
+			t.Errorf("expected pools to share connections, got %d", n)
+		}
+	}
+
+	if _, err := transports[0].NewVirtual(1); err == nil {
+		t.Fatal("expected error reusing a channel")
+	}
+	if _, err := transports[0].NewVirtual(0); err == nil {
+		t.Fatal("expected error for channel 0")
+	}
+
+	// Shutting down the WAN pool leaves the LAN pool running
+	for _, ml := range wan {
+		_ = ml.Shutdown()
+	}
+	if _, err := transports[0].NewVirtual(1); err != nil {
+		t.Fatalf("expected channel to be released on shutdown: %v", err)
+	}
+	if err := lan[1].UpdateNode(time.Second); err != nil {
+		t.Fatal(err)
+	}
+	waitForMembers(t, 3, lan...)
+}
+
+func TestVirtualConformance(t *testing.T) {
+	caCert, caKey, err := tlsutil.GenerateCA("test-org", 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	transporttest.Run(t, func(t *testing.T) memberlist.NodeAwareTransport {
+		transport, _ := createTestTransport(t, caCert, caKey, "node")
+		v, err := transport.NewVirtual(7)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	})
+}